@@ -3,9 +3,11 @@ module github.com/Advik-B/cloudscraper
 go 1.24.1
 
 require (
-	github.com/andybalholm/brotli v1.1.1 // indirect
-	github.com/robertkrimen/otto v0.5.1 // indirect
-	golang.org/x/net v0.41.0 // indirect
-	golang.org/x/text v0.26.0 // indirect
-	gopkg.in/sourcemap.v1 v1.0.5 // indirect
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.18.0
+	github.com/robertkrimen/otto v0.5.1
+	golang.org/x/net v0.41.0
+	golang.org/x/text v0.26.0
 )
+
+require gopkg.in/sourcemap.v1 v1.0.5 // indirect
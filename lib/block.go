@@ -0,0 +1,63 @@
+package cloudscraper
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
+)
+
+var (
+	// The HTML error page: <span class="cf-error-code">1020</span>.
+	cfErrorCodeRegex = regexp.MustCompile(`class="cf-error-code">\s*(\d{4})\s*<`)
+	// The plain-text body served to non-browser clients: "error code: 1020".
+	cfErrorTextRegex = regexp.MustCompile(`(?i)^\s*error code:\s*(\d{4})\s*$`)
+	// The title of the HTML error page: "Access denied | example.com | Cloudflare".
+	cfErrorTitleRegex = regexp.MustCompile(`(?i)<title>[^<]*\|\s*Cloudflare\s*</title>`)
+	cfRayBodyRegex    = regexp.MustCompile(`Ray ID:\s*(?:<[^>]+>\s*)*([0-9a-f]{16})`)
+)
+
+// isCloudflare reports whether resp was served by Cloudflare's edge.
+func isCloudflare(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Server"), "cloudflare") || resp.Header.Get("Cf-Ray") != ""
+}
+
+// detectBlock returns a BlockError if resp is one of Cloudflare's numbered
+// error pages, such as 1020 (access denied) or 1015 (rate limited).
+func detectBlock(resp *http.Response, body []byte) *errors.BlockError {
+	if resp.StatusCode < 400 || !isCloudflare(resp) {
+		return nil
+	}
+
+	m := cfErrorCodeRegex.FindSubmatch(body)
+	if m == nil {
+		// Only trust the bare "error code" text on Cloudflare's own short bodies.
+		if len(body) > 64 {
+			return nil
+		}
+		if m = cfErrorTextRegex.FindSubmatch(body); m == nil {
+			return nil
+		}
+	} else if !cfErrorTitleRegex.Match(body) {
+		return nil
+	}
+	code, _ := strconv.Atoi(string(m[1]))
+
+	block := &errors.BlockError{
+		Code:       code,
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		RayID:      resp.Header.Get("Cf-Ray"),
+	}
+	if block.RayID == "" {
+		if ray := cfRayBodyRegex.FindSubmatch(body); ray != nil {
+			block.RayID = string(ray[1])
+		}
+	}
+	if after, ok := retryAfter(resp); ok {
+		block.RetryAfter = after
+	}
+	return block
+}
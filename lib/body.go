@@ -0,0 +1,106 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
+)
+
+// sniffLimit is how much of a response body streaming mode reads to detect
+// challenges and block pages. Cloudflare's pages fit well within it.
+const sniffLimit = 128 << 10
+
+type streamKey struct{}
+
+// withStreaming streams the response to ctx's request, whatever
+// Options.Streaming says.
+func withStreaming(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamKey{}, true)
+}
+
+func (s *Scraper) streaming(ctx context.Context) bool {
+	return s.opts.Streaming || ctx.Value(streamKey{}) != nil
+}
+
+// readBody reads resp's body for challenge detection, leaving resp.Body to
+// be read from the start. Unless streaming, it reads the whole body into
+// memory. Streaming, it reads nothing of a response below 400 without a
+// Cf-Mitigated header, since that cannot be a challenge, and at most
+// sniffLimit bytes of any other, which resp.Body then replays ahead of the
+// rest. complete reports whether body holds the whole body.
+func (s *Scraper) readBody(ctx context.Context, resp *http.Response) (body []byte, complete bool, err error) {
+	if !s.streaming(ctx) {
+		body, err = s.bufferBody(resp)
+		return body, err == nil, err
+	}
+	if resp.StatusCode < 400 && resp.Header.Get("Cf-Mitigated") == "" {
+		return nil, false, nil
+	}
+
+	var prefix bytes.Buffer
+	_, err = io.CopyN(&prefix, resp.Body, sniffLimit)
+	switch err {
+	case io.EOF:
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(prefix.Bytes()))
+		return prefix.Bytes(), true, nil
+	case nil:
+		resp.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(prefix.Bytes()), resp.Body), Closer: resp.Body}
+		return prefix.Bytes(), false, nil
+	}
+	resp.Body.Close()
+	return nil, false, err
+}
+
+// bufferBody reads all of resp's body, up to Options.MaxBodyBytes, and
+// replaces it with an in-memory copy.
+func (s *Scraper) bufferBody(resp *http.Response) ([]byte, error) {
+	body, err := s.readAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// readAll reads r to the end, failing with errors.ErrBodyTooLarge once it
+// has read more than Options.MaxBodyBytes.
+func (s *Scraper) readAll(r io.Reader) ([]byte, error) {
+	max := s.opts.MaxBodyBytes
+	if max <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("%w: more than %d bytes", errors.ErrBodyTooLarge, max)
+	}
+	return data, nil
+}
+
+// prefixedBody is a streamed body whose first bytes were read for challenge
+// detection: it replays them, then continues from the network.
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// cancelOnClose is a body whose request's context is cancelled once the
+// body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,167 @@
+package cloudscraper
+
+import (
+	"bytes"
+	stderrors "errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/cftest"
+	"github.com/Advik-B/cloudscraper/lib/errors"
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// challengePage returns a managed challenge page as cftest serves it, with
+// its headers.
+func challengePage(t *testing.T) (http.Header, []byte) {
+	t.Helper()
+	srv := cftest.NewServer(cftest.Config{Challenge: cftest.Managed})
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Header, page
+}
+
+// padded returns page followed by n bytes of HTML comments.
+func padded(page []byte, n int) []byte {
+	padding := bytes.Repeat([]byte("<!-- padding -->\n"), n/17+1)
+	return append(append([]byte(nil), page...), padding[:n]...)
+}
+
+func TestReadBodyStreaming(t *testing.T) {
+	header, page := challengePage(t)
+	s := newTestScraper(t, WithStreaming(true))
+	respond := func(status int, header http.Header, body []byte) (*http.Response, *countingReader) {
+		src := &countingReader{r: bytes.NewReader(body)}
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(src), Request: req}, src
+	}
+
+	// A challenge page larger than sniffLimit is detected from its first
+	// sniffLimit bytes, and the body still reads from the start.
+	full := padded(page, 3*sniffLimit)
+	resp, src := respond(http.StatusForbidden, header, full)
+	body, complete, err := s.readBody(t.Context(), resp)
+	if err != nil || complete || len(body) != sniffLimit || src.n > sniffLimit {
+		t.Fatalf("read %d bytes (complete %v, %v) and %d from the network, want a %d-byte prefix", len(body), complete, err, src.n, sniffLimit)
+	}
+	if name, _ := s.Challenges.Detect(resp, body); name != ChallengeOrchestrate {
+		t.Errorf("prefix detected as %q, want %s", name, ChallengeOrchestrate)
+	}
+	if _, ok := resp.Body.(*prefixedBody); !ok {
+		t.Errorf("body is a %T, want a *prefixedBody", resp.Body)
+	}
+	if got, _ := io.ReadAll(resp.Body); !bytes.Equal(got, full) {
+		t.Errorf("replayed %d bytes, want the %d sent", len(got), len(full))
+	}
+
+	// A page within sniffLimit is read whole.
+	resp, _ = respond(http.StatusForbidden, header, page)
+	if body, complete, err = s.readBody(t.Context(), resp); err != nil || !complete || !bytes.Equal(body, page) {
+		t.Errorf("small page read as %d bytes (complete %v, %v), want all %d", len(body), complete, err, len(page))
+	}
+
+	// A successful response cannot be a challenge and is not read at all.
+	resp, src = respond(http.StatusOK, http.Header{}, full)
+	if body, _, err = s.readBody(t.Context(), resp); err != nil || body != nil || src.n != 0 {
+		t.Errorf("200 response read %d bytes (%v), want none", src.n, err)
+	}
+}
+
+func TestStreamingLargeBody(t *testing.T) {
+	const size = 8 << 20
+	content := padded(nil, size)
+	release := make(chan struct{})
+	srv := cftest.NewServer(cftest.Config{
+		Challenge: cftest.Managed,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(content[:sniffLimit])
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+			}
+			w.Write(content[sniffLimit:])
+		}),
+	})
+	defer srv.Close()
+	// The limit only applies to pages read into memory.
+	s := newTestScraper(t, WithStreaming(true), WithMaxBodyBytes(1<<10))
+
+	// Get returns while the origin is still holding back most of the body,
+	// so the scraper cannot have buffered it.
+	start := time.Now()
+	resp, err := s.Get(srv.URL + "/large")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+	if waited := time.Since(start); waited > 4*time.Second {
+		t.Errorf("Get took %v, waiting for the whole body", waited)
+	}
+	close(release)
+	got, err := io.ReadAll(resp.Body)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("streamed %d bytes (%v), want %d", len(got), err, size)
+	}
+	if stats := srv.Stats(); stats.Solved != 1 {
+		t.Errorf("solved %d challenges, want 1", stats.Solved)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	header, page := challengePage(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Write(padded(nil, 512))
+		case "/large":
+			w.Write(padded(nil, 4<<10))
+		case "/challenge":
+			// A challenge page over the limit is not read into memory to be solved.
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(padded(page, 2*sniffLimit))
+		}
+	}))
+	defer srv.Close()
+
+	s := newTestScraper(t, WithMaxBodyBytes(1<<10))
+	resp, err := s.Get(srv.URL + "/small")
+	if err != nil {
+		t.Fatalf("body within the limit: %v", err)
+	}
+	resp.Body.Close()
+	if _, err := s.Get(srv.URL + "/large"); !stderrors.Is(err, errors.ErrBodyTooLarge) {
+		t.Errorf("body over the limit gave %v, want ErrBodyTooLarge", err)
+	}
+
+	streaming := newTestScraper(t, WithStreaming(true), WithMaxBodyBytes(sniffLimit))
+	if _, err := streaming.Get(srv.URL + "/challenge"); !stderrors.Is(err, errors.ErrBodyTooLarge) {
+		t.Errorf("streamed challenge over the limit gave %v, want ErrBodyTooLarge", err)
+	}
+}
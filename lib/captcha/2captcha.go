@@ -1,18 +1,28 @@
 package captcha
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
+// defaultTwoCaptchaURL is the production 2captcha API endpoint.
+const defaultTwoCaptchaURL = "https://2captcha.com"
+
 // TwoCaptchaSolver implements the Solver interface for 2captcha.com.
 type TwoCaptchaSolver struct {
 	APIKey string
 	Client *http.Client
+	// BaseURL overrides the API endpoint, e.g. for a compatible service or a
+	// local fake. Defaults to https://2captcha.com.
+	BaseURL string
+	// PollInterval is the wait between result polls. Defaults to 5 seconds.
+	PollInterval time.Duration
 }
 
 type twoCaptchaRequest struct {
@@ -23,13 +33,15 @@ type twoCaptchaRequest struct {
 // NewTwoCaptchaSolver creates a new 2captcha solver.
 func NewTwoCaptchaSolver(apiKey string) *TwoCaptchaSolver {
 	return &TwoCaptchaSolver{
-		APIKey: apiKey,
-		Client: &http.Client{Timeout: 30 * time.Second},
+		APIKey:       apiKey,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+		BaseURL:      defaultTwoCaptchaURL,
+		PollInterval: 5 * time.Second,
 	}
 }
 
 // Solve sends a captcha to 2captcha and polls for the result.
-func (s *TwoCaptchaSolver) Solve(captchaType, pageURL, siteKey string) (string, error) {
+func (s *TwoCaptchaSolver) Solve(ctx context.Context, captchaType, pageURL, siteKey string) (string, error) {
 	// Map cloudscraper types to 2captcha method names
 	method := ""
 	switch captchaType {
@@ -51,7 +63,13 @@ func (s *TwoCaptchaSolver) Solve(captchaType, pageURL, siteKey string) (string,
 	form.Add("pageurl", pageURL)
 	form.Add("json", "1")
 
-	resp, err := s.Client.PostForm("https://2captcha.com/in.php", form)
+	submitReq, err := http.NewRequestWithContext(ctx, "POST", s.baseURL()+"/in.php", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("2captcha: failed to build submission: %w", err)
+	}
+	submitReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.Client.Do(submitReq)
 	if err != nil {
 		return "", fmt.Errorf("2captcha: failed to submit job: %w", err)
 	}
@@ -70,11 +88,11 @@ func (s *TwoCaptchaSolver) Solve(captchaType, pageURL, siteKey string) (string,
 	jobID := req.Request
 
 	// 2. Poll for the result
-	return s.pollForResult(jobID)
+	return s.pollForResult(ctx, jobID)
 }
 
-func (s *TwoCaptchaSolver) pollForResult(jobID string) (string, error) {
-	u, _ := url.Parse("https://2captcha.com/res.php")
+func (s *TwoCaptchaSolver) pollForResult(ctx context.Context, jobID string) (string, error) {
+	u, _ := url.Parse(s.baseURL() + "/res.php")
 	q := u.Query()
 	q.Set("key", s.APIKey)
 	q.Set("action", "get")
@@ -82,22 +100,27 @@ func (s *TwoCaptchaSolver) pollForResult(jobID string) (string, error) {
 	q.Set("json", "1")
 	u.RawQuery = q.Encode()
 
-	// Poll for 180 seconds with 5-second intervals
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	// Poll for up to 36 intervals (180 seconds at the default interval)
 	for i := 0; i < 36; i++ {
-		time.Sleep(5 * time.Second)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return "", fmt.Errorf("2captcha: polling aborted: %w", ctx.Err())
+		}
 
-		resp, err := s.Client.Get(u.String())
+		res, err := s.fetchResult(ctx, u.String())
 		if err != nil {
-			continue // Retry on network error
-		}
-		defer resp.Body.Close()
-		
-		body, _ := io.ReadAll(resp.Body)
-		var res twoCaptchaRequest
-		if err := json.Unmarshal(body, &res); err != nil {
-			continue // Retry on parsing error
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("2captcha: polling aborted: %w", ctx.Err())
+			}
+			continue // Retry on network or parsing error
 		}
-		
+
 		if res.Status == 1 {
 			return res.Request, nil // Success
 		}
@@ -107,4 +130,31 @@ func (s *TwoCaptchaSolver) pollForResult(jobID string) (string, error) {
 	}
 
 	return "", fmt.Errorf("2captcha: timeout waiting for solve")
-}
\ No newline at end of file
+}
+
+func (s *TwoCaptchaSolver) baseURL() string {
+	if s.BaseURL == "" {
+		return defaultTwoCaptchaURL
+	}
+	return strings.TrimSuffix(s.BaseURL, "/")
+}
+
+// fetchResult performs a single poll of the result endpoint.
+func (s *TwoCaptchaSolver) fetchResult(ctx context.Context, resultURL string) (*twoCaptchaRequest, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", resultURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	var res twoCaptchaRequest
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
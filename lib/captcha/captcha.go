@@ -1,6 +1,10 @@
 package captcha
 
+import "context"
+
 // Solver defines the interface for a captcha solving service.
 type Solver interface {
-	Solve(captchaType, url, siteKey string) (string, error)
-}
\ No newline at end of file
+	// Solve returns a token for the captcha identified by siteKey on url.
+	// Implementations must give up and return ctx.Err() once ctx is done.
+	Solve(ctx context.Context, captchaType, url, siteKey string) (string, error)
+}
@@ -0,0 +1,129 @@
+package cftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/captcha"
+)
+
+// CaptchaBackend is a fake 2captcha-compatible API. Jobs submitted to
+// /in.php are answered by /res.php with Token after NotReadyPolls polls.
+type CaptchaBackend struct {
+	*httptest.Server
+
+	// Token is returned for every solved job.
+	Token string
+	// NotReadyPolls is how many polls answer CAPCHA_NOT_READY before the token.
+	NotReadyPolls int
+
+	mu   sync.Mutex
+	jobs map[string]*captchaJob
+	next int
+}
+
+type captchaJob struct {
+	Method  string
+	SiteKey string
+	PageURL string
+	polls   int
+}
+
+// NewCaptchaBackend starts a fake solver that answers every job with token.
+func NewCaptchaBackend(token string) *CaptchaBackend {
+	b := &CaptchaBackend{
+		Token:         token,
+		NotReadyPolls: 1,
+		jobs:          make(map[string]*captchaJob),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/in.php", b.handleSubmit)
+	mux.HandleFunc("/res.php", b.handleResult)
+	b.Server = httptest.NewServer(mux)
+	return b
+}
+
+// Solver returns a TwoCaptchaSolver pointed at the backend with a short poll interval.
+func (b *CaptchaBackend) Solver() *captcha.TwoCaptchaSolver {
+	solver := captcha.NewTwoCaptchaSolver("cftest-api-key")
+	solver.BaseURL = b.URL
+	solver.PollInterval = 10 * time.Millisecond
+	return solver
+}
+
+// Jobs returns the number of jobs submitted so far.
+func (b *CaptchaBackend) Jobs() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.jobs)
+}
+
+func (b *CaptchaBackend) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("key") == "" {
+		writeCaptchaJSON(w, 0, "ERROR_WRONG_USER_KEY")
+		return
+	}
+	b.mu.Lock()
+	b.next++
+	id := fmt.Sprint(b.next)
+	b.jobs[id] = &captchaJob{Method: r.Form.Get("method"), SiteKey: r.Form.Get("googlekey"), PageURL: r.Form.Get("pageurl")}
+	b.mu.Unlock()
+	writeCaptchaJSON(w, 1, id)
+}
+
+func (b *CaptchaBackend) handleResult(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	job, ok := b.jobs[r.URL.Query().Get("id")]
+	ready := false
+	if ok {
+		job.polls++
+		ready = job.polls > b.NotReadyPolls
+	}
+	b.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeCaptchaJSON(w, 0, "ERROR_WRONG_CAPTCHA_ID")
+	case !ready:
+		writeCaptchaJSON(w, 0, "CAPCHA_NOT_READY")
+	default:
+		writeCaptchaJSON(w, 1, b.Token)
+	}
+}
+
+func writeCaptchaJSON(w http.ResponseWriter, status int, request string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "request": request})
+}
+
+// StaticSolver is an in-process captcha.Solver that always returns Token.
+// Calls records how many solves were requested.
+type StaticSolver struct {
+	Token string
+
+	mu    sync.Mutex
+	calls int
+}
+
+// Solve returns s.Token, or ctx's error if it is already done.
+func (s *StaticSolver) Solve(ctx context.Context, captchaType, url, siteKey string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+	return s.Token, nil
+}
+
+// Calls returns how many times Solve has been called.
+func (s *StaticSolver) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
@@ -0,0 +1,90 @@
+package cftest
+
+import (
+	"embed"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// The fixtures directory holds challenge pages, each listed in its kind's
+// index.json with the host it is served for and its answer.
+//
+//go:embed fixtures
+var fixtureFS embed.FS
+
+// Fixture is a challenge page with a known answer.
+type Fixture struct {
+	// Name identifies the fixture; it is the page's file name without its extension.
+	Name string
+	// Host is the host the page was served for. v1 answers depend on it.
+	Host string
+	// Page is the page's HTML.
+	Page string
+	// Answer is the jschl_answer the page computes when served for Host.
+	Answer string
+}
+
+// V1Fixtures returns the corpus of v1 challenge pages, with the answers a
+// browser computes for them. The pages follow the layouts Cloudflare served
+// over the years, with their hosts replaced by example domains. They cover
+// the variants the solver handles: answers computed with parseInt or
+// toFixed, with and without the domain's length, from the innerHTML of the
+// hidden cf-dn- element named by k, among decoys, and from the domain's
+// character codes.
+func V1Fixtures() []Fixture {
+	return loadFixtures("fixtures/v1")
+}
+
+func loadFixtures(dir string) []Fixture {
+	data, err := fixtureFS.ReadFile(path.Join(dir, "index.json"))
+	if err != nil {
+		panic("cftest: " + err.Error())
+	}
+	var index []struct {
+		Page   string `json:"page"`
+		Host   string `json:"host"`
+		Answer string `json:"answer"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		panic("cftest: invalid fixture index: " + err.Error())
+	}
+
+	fixtures := make([]Fixture, len(index))
+	for i, e := range index {
+		page, err := fixtureFS.ReadFile(path.Join(dir, e.Page))
+		if err != nil {
+			panic("cftest: " + err.Error())
+		}
+		fixtures[i] = Fixture{
+			Name:   strings.TrimSuffix(e.Page, path.Ext(e.Page)),
+			Host:   e.Host,
+			Page:   string(page),
+			Answer: e.Answer,
+		}
+	}
+	return fixtures
+}
+
+var hiddenFieldRegex = regexp.MustCompile(`name="(\w+)" value="([^"]*)"`)
+
+// field returns the value of the hidden form field name in the page.
+func (f *Fixture) field(name string) string {
+	for _, m := range hiddenFieldRegex.FindAllStringSubmatch(f.Page, -1) {
+		if m[1] == name {
+			return m[2]
+		}
+	}
+	return ""
+}
+
+// key returns the form field a submission of the page is matched by.
+func (f *Fixture) key() string {
+	for _, name := range []string{"r", "s", "jschl_vc"} {
+		if v := f.field(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
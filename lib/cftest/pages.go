@@ -0,0 +1,309 @@
+package cftest
+
+import (
+	"bytes"
+	"text/template"
+)
+
+type v1Page struct {
+	Ray, R, VC, Pass, Expr, Token string
+}
+
+type v2Page struct {
+	Ray, R, VC, Pass, Expr, Hash, Action string
+}
+
+type turnstilePage struct {
+	Ray, R, SiteKey, Action string
+}
+
+// The pages are rendered with text/template: html/template would escape the
+// "!+[]" arithmetic inside <script> blocks and break the solver. Every value
+// interpolated below is generated by the server itself.
+var (
+	v1Template = template.Must(template.New("v1").Parse(`<!DOCTYPE HTML>
+<html lang="en-US">
+<head>
+  <meta charset="UTF-8" />
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+  <meta http-equiv="X-UA-Compatible" content="IE=Edge,chrome=1" />
+  <meta name="robots" content="noindex, nofollow" />
+  <title>Just a moment...</title>
+  <script type="text/javascript">
+  //<![CDATA[
+  (function(){
+    var a = function() {try{return !!window.addEventListener} catch(e) {return !1} },
+    b = function(b, c) {a() ? document.addEventListener("DOMContentLoaded", b, c) : document.attachEvent("onreadystatechange", b)};
+    b(function(){
+      var a = document.getElementById('cf-content');a.style.display = 'block';
+      setTimeout(function(){
+        var s,t,o,p,b,r,e,a,k,i,n,g,f, xyz={"abc":+((!+[]+!![]+[])+(+!![]))};t = document.createElement('div');t.innerHTML="<a href='/'>x</a>";t = t.firstChild.href;r = t.match(/https?:\/\//)[0];t = t.substr(r.length); t = t.substr(0,t.length-1);a = document.getElementById('jschl-answer');f = document.getElementById('challenge-form');a.value = ({{.Expr}}+t.length).toFixed(10); '; 121'
+        f.action += location.hash;
+        f.submit();
+      }, 4000);
+    }, false);
+  })();
+  //]]>
+  </script>
+</head>
+<body>
+  <table width="100%" height="100%" cellpadding="20">
+    <tr>
+      <td align="center" valign="middle">
+        <div class="cf-browser-verification cf-im-under-attack">
+          <noscript><h1 data-translate="turn_on_js" style="color:#bd2426;">Please turn JavaScript on and reload the page.</h1></noscript>
+          <div id="cf-content" style="display:none">
+            <div><div class="bubbles"></div><div class="bubbles"></div><div class="bubbles"></div></div>
+            <h1><span data-translate="checking_browser">Checking your browser before accessing</span> the site.</h1>
+            <p data-translate="process_is_automatic">This process is automatic. Your browser will redirect to your requested content shortly.</p>
+            <p data-translate="allow_5_secs">Please allow up to 5 seconds&hellip;</p>
+          </div>
+          <form class="challenge-form" id="challenge-form" action="/cdn-cgi/l/chk_jschl?__cf_chl_jschl_tk__={{.Token}}" method="POST">
+            <input type="hidden" name="r" value="{{.R}}"></input>
+            <input type="hidden" name="jschl_vc" value="{{.VC}}"/>
+            <input type="hidden" name="pass" value="{{.Pass}}"/>
+            <input type="hidden" id="jschl-answer" name="jschl_answer"/>
+          </form>
+        </div>
+        <div class="attribution">
+          <a href="https://www.cloudflare.com/5xx-error-landing/" target="_blank" style="font-size: 12px;">DDoS protection by Cloudflare</a>
+          <br>
+          Ray ID: {{.Ray}}
+        </div>
+      </td>
+    </tr>
+  </table>
+  <img src="/cdn-cgi/images/trace/jsch/js/transparent.gif?ray={{.Ray}}" style="display: none" />
+</body>
+</html>
+`))
+
+	v2Template = template.Must(template.New("v2").Parse(`<!DOCTYPE html>
+<html lang="en-US">
+<head>
+  <title>Just a moment...</title>
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8">
+  <meta http-equiv="X-UA-Compatible" content="IE=Edge">
+  <meta name="robots" content="noindex,nofollow">
+  <meta name="viewport" content="width=device-width,initial-scale=1">
+  <script>
+    (function(){
+      window._cf_chl_opt={cvId: '2',cZone: 'localhost',cType: 'non-interactive',cNounce: '{{.Ray}}',cRay: '{{.Ray}}',cHash: '{{.Hash}}',cUPMDTk: "{{.Action}}",cFPWv: 'g',cTTimeMs: '1000',cMTimeMs: '0',cTplV: 5,cTplB: 'cf',cRq: {ru: 'aHR0cDovL2xvY2FsaG9zdC8=',ra: 'TW96aWxsYS81LjA=',rm: 'R0VU',d: '',t: 'MTcwMDAwMDAwMC4wMDAwMDA=',m: '',i1: '',i2: '',zh: '',uh: '',hh: ''}};
+      setTimeout(function(){
+        var a = document.getElementById('jschl-answer');
+        a.value = ({{.Expr}}+window._cf_chl_opt.cHash.length).toFixed(10);
+      }, 4000);
+    }());
+  </script>
+  <script src="/cdn-cgi/challenge-platform/h/g/orchestrate/jsch/v1?ray={{.Ray}}"></script>
+</head>
+<body class="no-js">
+  <div class="main-wrapper" role="main">
+    <div class="main-content">
+      <h1 class="zone-name-title h1">localhost</h1>
+      <h2 class="h2" id="challenge-running">Checking if the site connection is secure</h2>
+      <noscript><div id="challenge-error-title"><div class="h2"><span class="icon-wrapper"><div class="heading-icon warning-icon"></div></span><span id="challenge-error-text">Enable JavaScript and cookies to continue</span></div></div></noscript>
+      <form class="challenge-form" id="challenge-form" action="{{.Action}}" method="POST">
+        <input type="hidden" name="r" value="{{.R}}"/>
+        <input type="hidden" name="jschl_vc" value="{{.VC}}"/>
+        <input type="hidden" name="pass" value="{{.Pass}}"/>
+        <input type="hidden" id="jschl-answer" name="jschl_answer"/>
+      </form>
+    </div>
+  </div>
+  <div class="footer" role="contentinfo">
+    <div class="footer-inner">
+      <div class="clearfix diagnostic-wrapper"><div class="ray-id">Ray ID: <code>{{.Ray}}</code></div></div>
+      <div class="text-center" id="footer-text">Performance &amp; security by Cloudflare</div>
+    </div>
+  </div>
+</body>
+</html>
+`))
+
+	turnstileTemplate = template.Must(template.New("turnstile").Parse(`<!DOCTYPE html>
+<html lang="en-US">
+<head>
+  <title>Just a moment...</title>
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8">
+  <meta name="robots" content="noindex,nofollow">
+  <script src="https://challenges.cloudflare.com/turnstile/v0/api.js" async defer></script>
+</head>
+<body>
+  <div class="main-wrapper" role="main">
+    <div class="main-content">
+      <h2 class="h2" id="challenge-running">Verify you are human by completing the action below.</h2>
+      <form class="challenge-form" id="challenge-form" action="{{.Action}}" method="POST">
+        <input type="hidden" name="r" value="{{.R}}"/>
+        <div class="cf-turnstile" data-sitekey="{{.SiteKey}}" data-callback="onTurnstile"></div>
+      </form>
+    </div>
+  </div>
+  <div class="footer" role="contentinfo">
+    <div class="ray-id">Ray ID: <code>{{.Ray}}</code></div>
+  </div>
+</body>
+</html>
+`))
+)
+
+const forbiddenPage = `<!DOCTYPE html>
+<html lang="en-US">
+<head><title>Attention Required! | Cloudflare</title></head>
+<body>
+  <div id="cf-wrapper">
+    <h1 data-translate="block_headline">Sorry, you have been blocked</h1>
+    <h2 class="cf-subheadline"><span data-translate="unable_to_access">You are unable to access</span> this site</h2>
+  </div>
+</body>
+</html>
+`
+
+type blockPage struct {
+	Code  int
+	Title string
+	Host  string
+	RayID string
+}
+
+var blockTitles = map[int]string{
+	1006: "Access denied",
+	1010: "Access denied",
+	1015: "You are being rate limited",
+	1020: "Access denied",
+}
+
+var blockTemplate = template.Must(template.New("block").Parse(`<!DOCTYPE html>
+<html lang="en-US">
+<head><title>{{.Title}} | {{.Host}} | Cloudflare</title></head>
+<body>
+  <div id="cf-wrapper">
+    <div id="cf-error-details" class="cf-error-details-wrapper">
+      <h1 class="inline-block"><span data-translate="error">Error</span><span class="cf-error-code">{{.Code}}</span></h1>
+      <h2 class="cf-subheadline">{{.Title}}</h2>
+    </div>
+    <div class="cf-error-footer">
+      <span class="cf-footer-item">Cloudflare Ray ID: <strong class="font-semibold">{{.RayID}}</strong></span>
+    </div>
+  </div>
+</body>
+</html>
+`))
+
+// managedPage is a managed challenge. Its inline script only loads the
+// orchestrate script, which drives the flow. An interactive one carries a
+// Turnstile sitekey as well.
+type managedPage struct {
+	Ray, R, Nonce, Hash, MD, Zone, Action, SiteKey string
+}
+
+// orchestrateScript is the managed challenge's orchestrate script. It posts
+// the page's md and r to the flow endpoint, evaluates the second stage it
+// gets back, posts that stage's answer and, once the answer is accepted,
+// submits the challenge form.
+type orchestrateScript struct {
+	Flow string
+}
+
+var managedTemplate = template.Must(template.New("managed").Parse(`<!DOCTYPE html>
+<html lang="en-US">
+<head>
+  <title>Just a moment...</title>
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8">
+  <meta name="robots" content="noindex,nofollow">
+  <meta name="viewport" content="width=device-width,initial-scale=1">
+</head>
+<body>
+  <div class="main-wrapper" role="main">
+    <div class="main-content">
+      <h1 class="zone-name-title h1">{{.Zone}}</h1>
+      <h2 class="h2" id="challenge-running">Verifying you are human. This may take a few seconds.</h2>
+      <form id="challenge-form" action="{{.Action}}" method="POST" enctype="application/x-www-form-urlencoded">
+        <input type="hidden" name="md" value="{{.MD}}"/>
+        <input type="hidden" name="r" value="{{.R}}"/>
+      </form>
+    </div>
+  </div>
+  <script>
+    (function(){
+      window._cf_chl_opt={cvId: '3',cZone: '{{.Zone}}',cType: 'managed',cNounce: '{{.Nonce}}',cRay: '{{.Ray}}',cHash: '{{.Hash}}',cUPMDTk: "{{.Action}}",cFPWv: 'b',cTTimeMs: '1000',cMTimeMs: '120000',cTplV: 5,cTplB: 'cf',cK: "",{{if .SiteKey}}chlApiSitekey: '{{.SiteKey}}',{{end}}fa: "{{.Action}}",md: "{{.MD}}",cRq: {ru: 'aHR0cDovL2xvY2FsaG9zdC8=',ra: 'TW96aWxsYS81LjA=',rm: 'R0VU',d: '',t: 'MTcwMDAwMDAwMC4wMDAwMDA=',r: '{{.R}}'}};
+      var cpo = document.createElement('script');
+      cpo.src = '/cdn-cgi/challenge-platform/h/b/orchestrate/chl_page/v1?ray={{.Ray}}';
+      window._cf_chl_opt.cOgUHash = location.hash === '' && location.href.indexOf('#') !== -1 ? '#' : location.hash;
+      document.getElementsByTagName('head')[0].appendChild(cpo);
+    }());
+  </script>
+  <div class="footer" role="contentinfo">
+    <div class="ray-id">Ray ID: <code>{{.Ray}}</code></div>
+  </div>
+</body>
+</html>
+`))
+
+var orchestrateTemplate = template.Must(template.New("orchestrate").Parse(`(function(){
+  var o = window._cf_chl_opt;
+  var flow = '{{.Flow}}/' + o.cNounce + '/' + o.cRay + '/' + o.cHash;
+  var base = 'md=' + encodeURIComponent(o.md) + '&r=' + encodeURIComponent(o.cRq.r);
+  function post(body, done) {
+    var x = new XMLHttpRequest();
+    x.open('POST', flow, true);
+    x.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+    x.onreadystatechange = function() {
+      if (x.readyState === 4 && x.status === 200) done(x.responseText);
+    };
+    x.send(body);
+  }
+  post(base, function(stage) {
+    eval(stage);
+    var ctx = window.__cf_chl_ctx;
+    setTimeout(function() {
+      post(base + '&n=' + ctx.n + '&a=' + ctx.a(), function(res) {
+        if (res === 'ok') document.getElementById('challenge-form').submit();
+      });
+    }, o.cTTimeMs * 1);
+  });
+}());
+`))
+
+// The second stage of the flow, served by its first post.
+var stageTemplate = template.Must(template.New("stage").Parse(`window.__cf_chl_ctx={n: '{{.Nonce}}',a: function(){return ({{.Expr}}+window._cf_chl_opt.cHash.length).toFixed(10);}};`))
+
+type stagePage struct {
+	Nonce, Expr string
+}
+
+func renderManaged(p managedPage) string {
+	return execute(managedTemplate, p)
+}
+
+func renderOrchestrate(p orchestrateScript) string {
+	return execute(orchestrateTemplate, p)
+}
+
+func renderStage(p stagePage) string {
+	return execute(stageTemplate, p)
+}
+
+func renderBlock(p blockPage) string {
+	return execute(blockTemplate, p)
+}
+
+func renderV1(p v1Page) string {
+	return execute(v1Template, p)
+}
+
+func renderV2(p v2Page) string {
+	return execute(v2Template, p)
+}
+
+func renderTurnstile(p turnstilePage) string {
+	return execute(turnstileTemplate, p)
+}
+
+func execute(t *template.Template, data interface{}) string {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		panic(err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,167 @@
+package cloudscraper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
+)
+
+// challengeChain is the state machine a request's challenges go through. A
+// challenge normally moves to ActionSolve; one that keeps coming back after
+// being solved escalates to ActionCaptcha, then to ActionIdentity, and then
+// fails with errors.ErrChallengeLoop. The chain records every stage, so a
+// failure can report how the request got there.
+type challengeChain struct {
+	mu        sync.Mutex
+	stages    []errors.ChallengeStage
+	mark      int // the first stage since the last change of identity
+	escalated map[errors.StageAction]bool
+}
+
+type chainKey struct{}
+
+// withChallengeChain returns the chain of the request ctx belongs to,
+// starting one if ctx has none. Requests made to get past a challenge, such
+// as form submissions and replays, carry their request's chain.
+func withChallengeChain(ctx context.Context) (context.Context, *challengeChain) {
+	if c, ok := ctx.Value(chainKey{}).(*challengeChain); ok {
+		return ctx, c
+	}
+	c := &challengeChain{escalated: make(map[errors.StageAction]bool)}
+	return context.WithValue(ctx, chainKey{}, c), c
+}
+
+// plan decides what to do about the challenge in resp, detected by the
+// handler registered as name: solve it, or escalate because it came back
+// too often. It fails once the request has gone through
+// Options.MaxChallengeStages challenges, or has nothing left to escalate to.
+func (c *challengeChain) plan(s *Scraper, name string, resp *http.Response, body []byte) (errors.StageAction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if max := s.opts.MaxChallengeStages; max > 0 && len(c.stages) >= max {
+		return "", c.loopError(name, resp, fmt.Errorf("%w: gave up after %d challenge stages", errors.ErrChallengeLoop, len(c.stages)))
+	}
+
+	repeats := 0
+	for _, st := range c.stages[c.mark:] {
+		if st.Kind == name && sameChallengeURL(st.URL, resp.Request.URL) {
+			repeats++
+		}
+	}
+	if max := s.opts.MaxChallengeRepeats; max <= 0 || repeats < max {
+		return errors.ActionSolve, nil
+	}
+	switch {
+	case c.canEscalateToCaptcha(s, name, body):
+		return errors.ActionCaptcha, nil
+	case !c.escalated[errors.ActionIdentity]:
+		return errors.ActionIdentity, nil
+	}
+	return "", c.loopError(name, resp, fmt.Errorf("%w: %s challenge seen %d times", errors.ErrChallengeLoop, name, repeats))
+}
+
+// escalateToCaptcha reports whether a failed or repeated challenge detected
+// by name may be handed to the captcha solver instead.
+func (c *challengeChain) escalateToCaptcha(s *Scraper, name string, body []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.canEscalateToCaptcha(s, name, body)
+}
+
+func (c *challengeChain) canEscalateToCaptcha(s *Scraper, name string, body []byte) bool {
+	return !c.escalated[errors.ActionCaptcha] && name != ChallengeCaptcha &&
+		s.CaptchaSolver != nil && inspectChallenge(body).SiteKey != ""
+}
+
+// record adds a stage for the challenge in resp and returns its index.
+func (c *challengeChain) record(name string, resp *http.Response, action errors.StageAction) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stages = append(c.stages, errors.ChallengeStage{
+		Kind:       name,
+		Action:     action,
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		RayID:      resp.Header.Get("Cf-Ray"),
+	})
+	switch action {
+	case errors.ActionCaptcha:
+		c.escalated[action] = true
+	case errors.ActionIdentity:
+		// The new identity starts over; only escalation to it is spent.
+		c.escalated[action] = true
+		c.mark = len(c.stages)
+	}
+	return len(c.stages) - 1
+}
+
+// settle records the outcome of stage i.
+func (c *challengeChain) settle(i int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stages[i].Err = err
+}
+
+// history returns a copy of the stages so far.
+func (c *challengeChain) history() []errors.ChallengeStage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.historyLocked()
+}
+
+func (c *challengeChain) historyLocked() []errors.ChallengeStage {
+	return append([]errors.ChallengeStage(nil), c.stages...)
+}
+
+// fail attaches the chain's history to err if it is a ChallengeError. The
+// error may be shared with requests waiting on the same solve, so it is
+// copied rather than modified.
+func (c *challengeChain) fail(err error) error {
+	ce, ok := err.(*errors.ChallengeError)
+	if !ok {
+		return err
+	}
+	failed := *ce
+	failed.History = c.history()
+	return &failed
+}
+
+func (c *challengeChain) loopError(name string, resp *http.Response, err error) error {
+	return &errors.ChallengeError{
+		Kind:       name,
+		Stage:      errors.StageVerify,
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		RayID:      resp.Header.Get("Cf-Ray"),
+		Err:        err,
+		History:    c.historyLocked(),
+	}
+}
+
+// fromLaterStage reports whether err is the failure of a challenge that
+// followed the one being solved, which has already been recorded and
+// given its history.
+func fromLaterStage(err error) bool {
+	ce, ok := err.(*errors.ChallengeError)
+	return ok && ce.History != nil
+}
+
+// sameChallengeURL reports whether a challenge recorded for recorded was
+// served for u as well. The query is ignored, since challenge URLs carry
+// per-challenge tokens.
+func sameChallengeURL(recorded string, u *url.URL) bool {
+	r, err := url.Parse(recorded)
+	return err == nil && r.Scheme == u.Scheme && r.Host == u.Host && pathOf(r) == pathOf(u)
+}
+
+func pathOf(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
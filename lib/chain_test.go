@@ -0,0 +1,103 @@
+package cloudscraper
+
+import (
+	stderrors "errors"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/Advik-B/cloudscraper/lib/cftest"
+	"github.com/Advik-B/cloudscraper/lib/errors"
+)
+
+// loopingServer serves a managed challenge that, once solved, is followed
+// by the same challenge again, as many times as a request can go through.
+func loopingServer(interactive bool) *cftest.Server {
+	then := make([]cftest.Kind, 20)
+	for i := range then {
+		then[i] = cftest.Managed
+	}
+	return cftest.NewServer(cftest.Config{Challenge: cftest.Managed, Then: then, Interactive: interactive})
+}
+
+func TestChallengeLoop(t *testing.T) {
+	solve, captcha, identity := errors.ActionSolve, errors.ActionCaptcha, errors.ActionIdentity
+	tests := []struct {
+		name        string
+		interactive bool
+		opts        []ScraperOption
+		actions     []errors.StageAction
+		solved      int
+	}{
+		{
+			name:        "captcha, then fresh identity",
+			interactive: true,
+			actions:     []errors.StageAction{solve, solve, captcha, identity, solve, solve},
+			solved:      5,
+		},
+		{
+			name:    "no captcha widget",
+			actions: []errors.StageAction{solve, solve, identity, solve, solve},
+			solved:  4,
+		},
+		{
+			name:    "stage limit",
+			opts:    []ScraperOption{WithChallengeLimits(3, 0)},
+			actions: []errors.StageAction{solve, solve, solve},
+			solved:  3,
+		},
+		{
+			name:    "more repeats allowed",
+			opts:    []ScraperOption{WithChallengeLimits(0, 3)},
+			actions: []errors.StageAction{solve, solve, solve, identity, solve, solve, solve},
+			solved:  6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := loopingServer(tt.interactive)
+			defer srv.Close()
+			opts := append([]ScraperOption{
+				WithCaptchaSolver(&cftest.StaticSolver{Token: srv.CaptchaToken()}),
+				WithMaxRetries(0),
+			}, tt.opts...)
+			s := newTestScraper(t, opts...)
+
+			// The root is challenged like any page, so the fresh identity's
+			// warm-up request goes through the same loop.
+			resp, err := s.Get(srv.URL + "/")
+			if err == nil {
+				resp.Body.Close()
+				t.Fatalf("got %d after %d solves, want ErrChallengeLoop", resp.StatusCode, srv.Stats().Solved)
+			}
+			var ce *errors.ChallengeError
+			if !stderrors.Is(err, errors.ErrChallengeLoop) || !stderrors.As(err, &ce) {
+				t.Fatalf("got %v, want a ChallengeError matching ErrChallengeLoop", err)
+			}
+
+			var actions []errors.StageAction
+			for _, st := range ce.History {
+				actions = append(actions, st.Action)
+				if st.Kind != ChallengeOrchestrate || st.StatusCode != 403 || st.Err != nil {
+					t.Errorf("stage %+v, want a settled %s challenge", st, ChallengeOrchestrate)
+				}
+				if u, _ := url.Parse(st.URL); u == nil || pathOf(u) != "/" {
+					t.Errorf("stage recorded for %s, want the requested URL", st.URL)
+				}
+			}
+			if !slices.Equal(actions, tt.actions) {
+				t.Errorf("stages %v, want %v", actions, tt.actions)
+			}
+			if stats := srv.Stats(); stats.Solved != tt.solved || stats.Failed != 0 {
+				t.Errorf("solved %d and failed %d challenges, want %d and 0", stats.Solved, stats.Failed, tt.solved)
+			}
+			var wantGen uint64
+			if slices.Contains(tt.actions, identity) {
+				wantGen = 1
+			}
+			if _, gen := s.identity(); gen != wantGen {
+				t.Errorf("session generation %d, want %d", gen, wantGen)
+			}
+		})
+	}
+}
@@ -1,8 +1,9 @@
 package cloudscraper
 
 import (
+	"context"
+	stderrors "errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -13,156 +14,331 @@ import (
 )
 
 var (
-	jsV1DetectRegex    = regexp.MustCompile(`(?i)cdn-cgi/images/trace/jsch/`)
 	jsV2DetectRegex    = regexp.MustCompile(`(?i)/cdn-cgi/challenge-platform/`)
 	captchaDetectRegex = regexp.MustCompile(`data-sitekey="([^\"]+)"`)
-	challengeFormRegex = regexp.MustCompile(`<form class="challenge-form" id="challenge-form" action="(.+?)" method="POST">`)
-	jschlVcRegex       = regexp.MustCompile(`name="jschl_vc" value="(\w+)"`)
-	passRegex          = regexp.MustCompile(`name="pass" value="(.+?)"`)
 )
 
-func (s *Scraper) handleChallenge(resp *http.Response) (*http.Response, error) {
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+// handleChallenge gets past the challenge that resp, the response to req,
+// carries and returns the response to req once it is let through. A
+// clearance in the ClearanceStore issued to the same identity is reused
+// when there is one. Otherwise the challenge is solved once per host: a
+// request challenged while another solve for its host is running waits for
+// that solve and is then replayed with the clearance it produced. A solve
+// only counts once it has left a cf_clearance cookie, which is then tracked
+// for Scraper.Clearance and published to the store.
+//
+// Every challenge is a stage of the request's challengeChain, which bounds
+// how many a request goes through and escalates one that keeps coming back.
+func (s *Scraper) handleChallenge(ctx context.Context, req *http.Request, resp *http.Response, body []byte, name string, h ChallengeHandler) (*http.Response, error) {
+	ctx, chain := withChallengeChain(ctx)
+	action, err := chain.plan(s, name, resp, body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read challenge response body: %w", err)
+		resp.Body.Close()
+		return nil, err
 	}
-	bodyStr := string(body)
-
-	// Check for modern v2/v3 JS VM challenge first
-	if jsV2DetectRegex.MatchString(bodyStr) {
-		s.logger.Printf("Modern (v2/v3) JavaScript challenge detected. Solving with '%s'...\n", s.opts.JSRuntime)
-		return s.solveModernJSChallenge(resp, bodyStr)
+	if action == errors.ActionIdentity {
+		resp.Body.Close()
+		return s.escalateIdentity(ctx, chain, req, resp, name)
 	}
 
-	// Check for classic lib JS challenge
-	if jsV1DetectRegex.MatchString(bodyStr) {
-		s.logger.Printf("Classic (v1) JavaScript challenge detected. Solving with '%s'...\n", s.opts.JSRuntime)
-		return s.solveClassicJSChallenge(resp.Request.URL, bodyStr)
+	key := s.clearanceKey(ctx, resp.Request)
+	if action == errors.ActionSolve && s.restoreClearance(ctx, key, resp) {
+		resp.Body.Close()
+		chain.record(name, resp, errors.ActionReuse)
+		s.logger.Printf("Reusing stored clearance for %s\n", key.Domain)
+		replay, err := cloneForReplay(req)
+		if err != nil {
+			return nil, err
+		}
+		return s.do(replay)
 	}
 
-	// Check for Captcha/Turnstile
-	if siteKeyMatch := captchaDetectRegex.FindStringSubmatch(bodyStr); len(siteKeyMatch) > 1 {
-		s.logger.Println("Captcha/Turnstile challenge detected...")
-		return s.solveCaptchaChallenge(resp, bodyStr, siteKeyMatch[1])
+	host := req.URL.Hostname()
+	flight, leader := s.joinFlight(host)
+	if !leader && !leadsFlight(ctx, flight) {
+		resp.Body.Close()
+		i := chain.record(name, resp, errors.ActionWait)
+		s.logger.Printf("Waiting for a concurrent challenge solve on %s\n", host)
+		err := s.awaitFlight(ctx, flight)
+		chain.settle(i, err)
+		if err != nil {
+			return nil, err
+		}
+		replay, err := cloneForReplay(req)
+		if err != nil {
+			return nil, err
+		}
+		return s.do(replay)
 	}
 
-	return nil, errors.ErrUnknownChallenge
+	// A request challenged again while its own solve runs is not the leader
+	// but must not wait on itself, so it solves inline.
+	s.logger.Printf("Challenge %q detected, attempting to bypass...\n", name)
+	solveCtx := ctx
+	if leader {
+		solveCtx = withFlight(ctx, flight)
+	}
+	solved, err := s.solveStage(solveCtx, chain, action, resp, body, name, h)
+	if leader {
+		s.finishFlight(host, flight, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.publishClearance(ctx, key, req.URL)
+	return s.replayAfterChallenge(req, solved)
 }
 
-func (s *Scraper) solveClassicJSChallenge(originalURL *url.URL, body string) (*http.Response, error) {
-	time.Sleep(4 * time.Second)
+// solveStage solves the challenge in resp as a stage of chain, with h or,
+// for ActionCaptcha, with the captcha solver. A failed solve escalates to
+// the captcha solver when the chain allows it.
+func (s *Scraper) solveStage(ctx context.Context, chain *challengeChain, action errors.StageAction, resp *http.Response, body []byte, name string, h ChallengeHandler) (*http.Response, error) {
+	for {
+		if action == errors.ActionCaptcha {
+			s.logger.Printf("Escalating challenge %q to the captcha solver\n", name)
+			h = captchaHandler{}
+		}
+		i := chain.record(name, resp, action)
+		solved, err := s.solveWithTimeout(ctx, resp, body, name, h)
+		if err == nil && solved.StatusCode == http.StatusForbidden {
+			solved.Body.Close()
+			err = rejectedError(name, solved)
+		}
+		if err == nil {
+			err = s.verifyClearance(name, resp, solved)
+		}
+		if err == nil {
+			return solved, nil
+		}
+		if fromLaterStage(err) {
+			// This stage got through; the challenge that followed it did not.
+			return nil, err
+		}
+		chain.settle(i, err)
+		if ctx.Err() != nil || action != errors.ActionSolve || !chain.escalateToCaptcha(s, name, body) {
+			return nil, chain.fail(err)
+		}
+		s.logger.Printf("Solving challenge %q failed: %v\n", name, err)
+		action = errors.ActionCaptcha
+	}
+}
 
-	answer, err := solveV1Logic(body, originalURL.Host, s.jsEngine)
+// escalateIdentity gives up on the challenge in resp, which keeps coming
+// back, and replays req under a fresh browser identity.
+func (s *Scraper) escalateIdentity(ctx context.Context, chain *challengeChain, req *http.Request, resp *http.Response, name string) (*http.Response, error) {
+	i := chain.record(name, resp, errors.ActionIdentity)
+	s.logger.Printf("Challenge %q keeps coming back, retrying %s with a fresh identity\n", name, req.URL)
+	agent, gen := s.identity()
+	if err := s.refreshSession(ctx, req.URL, gen); err != nil {
+		if fromLaterStage(err) {
+			return nil, err
+		}
+		chain.settle(i, err)
+		return nil, chain.fail(err)
+	}
+	replay, err := cloneForIdentity(req, agent)
 	if err != nil {
-		return nil, fmt.Errorf("v1 challenge solver failed: %w", err)
+		return nil, err
 	}
+	return s.do(replay)
+}
 
-	formMatch := challengeFormRegex.FindStringSubmatch(body)
-	if len(formMatch) < 2 {
-		return nil, fmt.Errorf("v1: could not find challenge form")
+// solveWithTimeout solves the challenge in resp with h, registered as name,
+// within Options.ChallengeTimeout. Failures are reported as an
+// *errors.ChallengeError, and an overrun also matches
+// errors.ErrChallengeTimeout; the default retry policy retries both.
+func (s *Scraper) solveWithTimeout(ctx context.Context, resp *http.Response, body []byte, name string, h ChallengeHandler) (*http.Response, error) {
+	resp.Body.Close()
+	solveCtx, cancel := context.WithCancelCause(ctx)
+	if d := s.opts.ChallengeTimeout; d > 0 {
+		timer := time.AfterFunc(d, func() { cancel(errors.ErrChallengeTimeout) })
+		defer timer.Stop()
 	}
-	vcMatch := jschlVcRegex.FindStringSubmatch(body)
-	if len(vcMatch) < 2 {
-		return nil, fmt.Errorf("v1: could not find jschl_vc")
+
+	solved, err := h.Solve(solveCtx, s, resp, body)
+	if err == nil {
+		if s.streaming(ctx) {
+			// The solved response's body is still to be read under solveCtx,
+			// so the context ends when the body is closed rather than now.
+			solved.Body = &cancelOnClose{ReadCloser: solved.Body, cancel: func() { cancel(nil) }}
+			return solved, nil
+		}
+		cancel(nil)
+		return solved, nil
 	}
-	passMatch := passRegex.FindStringSubmatch(body)
-	if len(passMatch) < 2 {
-		return nil, fmt.Errorf("v1: could not find pass")
+	timedOut := context.Cause(solveCtx) == errors.ErrChallengeTimeout
+	cancel(nil)
+	if ctx.Err() != nil {
+		// The caller gave up; report their context error, not ours.
+		return nil, ctx.Err()
 	}
 
-	fullSubmitURL, _ := originalURL.Parse(formMatch[1])
-	formData := url.Values{
-		"r":            {s.extractRValue(body)},
-		"jschl_vc":     {vcMatch[1]},
-		"pass":         {passMatch[1]},
-		"jschl_answer": {answer},
+	var ce *errors.ChallengeError
+	if !stderrors.As(err, &ce) {
+		ce = &errors.ChallengeError{Stage: errors.StageExecute, Err: err}
 	}
+	if ce.Kind == "" {
+		ce.Kind = name
+	}
+	if ce.URL == "" {
+		ce.URL = resp.Request.URL.String()
+		ce.StatusCode = resp.StatusCode
+		ce.RayID = resp.Header.Get("Cf-Ray")
+	}
+	if timedOut {
+		ce.Err = fmt.Errorf("%w after %v: %w", errors.ErrChallengeTimeout, s.opts.ChallengeTimeout, ce.Err)
+	}
+	return nil, ce
+}
 
-	return s.submitChallengeForm(fullSubmitURL.String(), originalURL.String(), formData)
+// stageError attributes err to stage of a challenge of the given kind,
+// unless it already is a ChallengeError, e.g. from a nested challenge.
+func stageError(kind string, stage errors.Stage, err error) error {
+	var ce *errors.ChallengeError
+	if stderrors.As(err, &ce) {
+		return err
+	}
+	return &errors.ChallengeError{Kind: kind, Stage: stage, Err: err}
 }
 
-func (s *Scraper) solveModernJSChallenge(resp *http.Response, body string) (*http.Response, error) {
-	answer, err := solveV2Logic(body, resp.Request.URL.Host, s.jsEngine, s.logger)
-	if err != nil {
-		return nil, fmt.Errorf("v2 challenge solver failed: %w", err)
+// rejectedError reports that Cloudflare answered a submitted solution with resp,
+// a 403.
+func rejectedError(kind string, resp *http.Response) error {
+	return &errors.ChallengeError{
+		Kind:       kind,
+		Stage:      errors.StageVerify,
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		RayID:      resp.Header.Get("Cf-Ray"),
+		Err:        errors.ErrChallengeRejected,
 	}
+}
 
-	formMatch := challengeFormRegex.FindStringSubmatch(body)
-	if len(formMatch) < 2 {
-		return nil, fmt.Errorf("v2: could not find challenge form")
+// extractError reports a part of a challenge page that could not be found.
+func extractError(kind, what string) error {
+	return &errors.ChallengeError{Kind: kind, Stage: errors.StageExtract, Err: fmt.Errorf("could not find %s", what)}
+}
+
+func (s *Scraper) solveClassicJSChallenge(ctx context.Context, originalURL *url.URL, body string) (*http.Response, error) {
+	form := parseChallengeForm(originalURL, []byte(body))
+	if form == nil {
+		return nil, extractError("v1", "challenge form")
 	}
-	vcMatch := jschlVcRegex.FindStringSubmatch(body)
-	if len(vcMatch) < 2 {
-		// v2 challenges sometimes don't have a jschl_vc. This is okay.
-		vcMatch = []string{"", ""}
+	for _, field := range []string{"jschl_vc", "pass"} {
+		if !form.Fields.Has(field) {
+			return nil, extractError("v1", field)
+		}
 	}
-	passMatch := passRegex.FindStringSubmatch(body)
-	if len(passMatch) < 2 {
-		return nil, fmt.Errorf("v2: could not find pass")
+
+	// Cloudflare rejects answers submitted before its 4 second countdown ends.
+	if err := sleepContext(ctx, 4*time.Second); err != nil {
+		return nil, err
 	}
 
-	fullSubmitURL, _ := resp.Request.URL.Parse(formMatch[1])
-	formData := url.Values{
-		"r":            {s.extractRValue(body)},
-		"jschl_vc":     {vcMatch[1]},
-		"pass":         {passMatch[1]},
-		"jschl_answer": {answer},
+	answer, err := solveV1Logic(ctx, body, originalURL.Host, s.jsEngine)
+	if err != nil {
+		return nil, stageError("v1", errors.StageExecute, err)
 	}
+	form.Fields.Set("jschl_answer", answer)
 
-	return s.submitChallengeForm(fullSubmitURL.String(), resp.Request.URL.String(), formData)
+	return s.submitChallengeForm(ctx, "v1", form, originalURL.String())
 }
 
-func (s *Scraper) solveCaptchaChallenge(resp *http.Response, body, siteKey string) (*http.Response, error) {
-	if s.CaptchaSolver == nil {
-		return nil, errors.ErrNoCaptchaSolver
+func (s *Scraper) solveModernJSChallenge(ctx context.Context, resp *http.Response, body string) (*http.Response, error) {
+	form := parseChallengeForm(resp.Request.URL, []byte(body))
+	if form == nil {
+		return nil, extractError("v2", "challenge form")
+	}
+	// v2 challenges sometimes don't have a jschl_vc. This is okay.
+	if !form.Fields.Has("pass") {
+		return nil, extractError("v2", "pass")
 	}
 
-	token, err := s.CaptchaSolver.Solve("turnstile", resp.Request.URL.String(), siteKey)
+	answer, err := solveV2Logic(ctx, body, resp.Request.URL.Host, s.jsEngine, s.logger)
 	if err != nil {
-		return nil, fmt.Errorf("captcha solver failed: %w", err)
+		return nil, stageError("v2", errors.StageExecute, err)
 	}
+	form.Fields.Set("jschl_answer", answer)
+
+	return s.submitChallengeForm(ctx, "v2", form, resp.Request.URL.String())
+}
 
-	formMatch := challengeFormRegex.FindStringSubmatch(body)
-	if len(formMatch) < 2 {
-		return nil, fmt.Errorf("captcha: could not find challenge form")
+// captchaTypes maps a captcha kind to the type name passed to captcha.Solver,
+// and to the form field its token is submitted in.
+var captchaTypes = map[string]struct{ solverType, field string }{
+	"turnstile": {"turnstile", "cf-turnstile-response"},
+	"hcaptcha":  {"hCaptcha", "h-captcha-response"},
+	"recaptcha": {"reCaptcha", "g-recaptcha-response"},
+}
+
+// captchaKind identifies the captcha widget embedded in a challenge page.
+func captchaKind(body string) string {
+	switch {
+	case strings.Contains(body, "cf-turnstile"), strings.Contains(body, "challenges.cloudflare.com/turnstile"):
+		return "turnstile"
+	case strings.Contains(body, "h-captcha"), strings.Contains(body, "hcaptcha.com"):
+		return "hcaptcha"
+	case strings.Contains(body, "g-recaptcha"), strings.Contains(body, "google.com/recaptcha"):
+		return "recaptcha"
 	}
-	submitURL, _ := resp.Request.URL.Parse(formMatch[1])
+	return "turnstile"
+}
 
-	formData := url.Values{
-		"r":                     {s.extractRValue(body)},
-		"cf-turnstile-response": {token},
-		"g-recaptcha-response":  {token},
+func (s *Scraper) solveCaptchaChallenge(ctx context.Context, resp *http.Response, body, siteKey string) (*http.Response, error) {
+	kind := captchaKind(body)
+	if s.CaptchaSolver == nil {
+		return nil, &errors.ChallengeError{Kind: kind, Stage: errors.StageExecute, Err: errors.ErrNoCaptchaSolver}
 	}
 
-	return s.submitChallengeForm(submitURL.String(), resp.Request.URL.String(), formData)
-}
+	form := parseChallengeForm(resp.Request.URL, []byte(body))
+	if form == nil {
+		return nil, extractError(kind, "challenge form")
+	}
 
-func (s *Scraper) submitChallengeForm(submitURL, refererURL string, formData url.Values) (*http.Response, error) {
-	req, _ := http.NewRequest("POST", submitURL, strings.NewReader(formData.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Referer", refererURL)
+	captchaType := captchaTypes[kind]
+	token, err := s.CaptchaSolver.Solve(ctx, captchaType.solverType, resp.Request.URL.String(), siteKey)
+	if err != nil {
+		return nil, stageError(kind, errors.StageExecute, fmt.Errorf("captcha solver failed: %w", err))
+	}
+	form.Fields.Set(captchaType.field, token)
+	form.Fields.Set("g-recaptcha-response", token)
 
-	// Use the main `do` method to ensure all headers and logic are applied
-	return s.do(req)
+	return s.submitChallengeForm(ctx, kind, form, resp.Request.URL.String())
 }
 
-func (s *Scraper) extractRValue(body string) string {
-	rValMatch := regexp.MustCompile(`name="r" value="([^"]+)"`).FindStringSubmatch(body)
-	if len(rValMatch) > 1 {
-		return rValMatch[1]
+// submitChallengeForm submits form, filled in with the answer, from the
+// challenge page at refererURL.
+func (s *Scraper) submitChallengeForm(ctx context.Context, kind string, form *challengeForm, refererURL string) (*http.Response, error) {
+	var req *http.Request
+	if form.Method == http.MethodGet {
+		action := *form.Action
+		action.RawQuery = mergeQuery(action.RawQuery, form.Fields)
+		req, _ = http.NewRequestWithContext(ctx, http.MethodGet, action.String(), nil)
+	} else {
+		req, _ = http.NewRequestWithContext(ctx, form.Method, form.Action.String(), strings.NewReader(form.Fields.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
-	return ""
-}
+	req.Header.Set("Referer", refererURL)
 
-func isChallengeResponse(resp *http.Response, body []byte) bool {
-	if !strings.HasPrefix(resp.Header.Get("Server"), "cloudflare") {
-		return false
+	// Use the main `do` method to ensure all headers and logic are applied.
+	// A refused answer comes back as a 403, which must not set off a session
+	// refresh: its warm-up request would be challenged and solved again.
+	req = req.WithContext(context.WithValue(ctx, in403RetryKey{}, true))
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, stageError(kind, errors.StageSubmit, err)
 	}
-	if resp.StatusCode != http.StatusServiceUnavailable && resp.StatusCode != http.StatusForbidden {
-		return false
+	if resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, rejectedError(kind, resp)
 	}
+	return resp, nil
+}
 
-	bodyStr := string(body)
-	return jsV1DetectRegex.MatchString(bodyStr) || jsV2DetectRegex.MatchString(bodyStr) || captchaDetectRegex.MatchString(bodyStr)
+// mergeQuery appends fields to the raw query string query.
+func mergeQuery(query string, fields url.Values) string {
+	if query == "" || len(fields) == 0 {
+		return query + fields.Encode()
+	}
+	return query + "&" + fields.Encode()
 }
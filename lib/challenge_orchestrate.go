@@ -0,0 +1,202 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
+	"github.com/Advik-B/cloudscraper/lib/js"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// orchestrateDetectRegex matches the orchestrate scripts that drive managed
+// challenges, as opposed to the classic jsch script.
+var orchestrateDetectRegex = regexp.MustCompile(`/cdn-cgi/challenge-platform/h/[a-z]/orchestrate/(?:chl_page|managed|captcha)/`)
+
+// orchestrateHandler solves managed challenges, whose orchestrate script
+// talks to the challenge platform's flow endpoints before the challenge
+// form is submitted, rather than filling in a jschl-answer.
+type orchestrateHandler struct{}
+
+func (h orchestrateHandler) Detect(resp *http.Response, body []byte) bool {
+	return h.detect(DetectChallenge(resp, body), body)
+}
+
+func (orchestrateHandler) detect(d Detection, body []byte) bool {
+	return d.Challenge && d.Has(SignalPlatform) && orchestrateDetectRegex.Match(body) &&
+		!bytes.Contains(body, []byte("jschl-answer"))
+}
+
+func (orchestrateHandler) Solve(ctx context.Context, s *Scraper, resp *http.Response, body []byte) (*http.Response, error) {
+	info := inspectChallenge(body)
+	s.logger.Printf("Managed challenge (type %q, version %q) detected. Running its orchestrate flow with '%s'...\n", info.Type, info.Version, s.opts.JSRuntime)
+	return s.solveOrchestrateChallenge(ctx, resp, body, info)
+}
+
+// solveOrchestrateChallenge runs the managed challenge page in resp through
+// js.RunFlow, performing the requests its scripts make with the scraper, and
+// then does what the scripts left the page to do: submit the challenge form,
+// or navigate.
+func (s *Scraper) solveOrchestrateChallenge(ctx context.Context, resp *http.Response, body []byte, info *ChallengeInfo) (*http.Response, error) {
+	kind := info.Type
+	if kind == "" {
+		kind = "managed"
+	}
+	page := resp.Request.URL
+	scripts, elements := pageScripts(body)
+	if len(scripts) == 0 {
+		return nil, extractError(kind, "challenge scripts")
+	}
+
+	result, err := js.RunFlow(ctx, s.jsEngine, js.Page{
+		URL:       page,
+		UserAgent: resp.Request.Header.Get("User-Agent"),
+		Scripts:   scripts,
+		Elements:  elements,
+	}, func(ctx context.Context, r js.Request) (js.Response, error) {
+		return s.flowFetch(ctx, page, r)
+	})
+	if err != nil {
+		return nil, stageError(kind, errors.StageExecute, err)
+	}
+	for _, line := range result.Cookies {
+		if cookie, err := http.ParseSetCookie(line); err == nil {
+			s.jar.SetCookies(page, []*http.Cookie{cookie})
+		}
+	}
+
+	switch {
+	case result.Submit != "":
+		form := parseChallengeForm(page, body)
+		if form == nil {
+			return nil, extractError(kind, "challenge form")
+		}
+		for name, value := range result.Fields {
+			if form.Fields.Has(name) {
+				form.Fields.Set(name, value)
+			}
+		}
+		return s.submitChallengeForm(ctx, kind, form, page.String())
+	case result.Reload || result.Location != page.String():
+		target := page
+		if !result.Reload {
+			if target, err = page.Parse(result.Location); err != nil {
+				return nil, stageError(kind, errors.StageSubmit, err)
+			}
+		}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+		req.Header.Set("Referer", page.String())
+		solved, err := s.do(req)
+		if err != nil {
+			return nil, stageError(kind, errors.StageSubmit, err)
+		}
+		return solved, nil
+	}
+
+	err = fmt.Errorf("challenge scripts finished without submitting the challenge")
+	if len(result.Errors) > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.Join(result.Errors, "; "))
+	}
+	return nil, stageError(kind, errors.StageExecute, err)
+}
+
+// flowFetch performs a request made by the scripts of the challenge page at
+// page, through the scraper so that it carries the session's identity,
+// cookies and proxy.
+func (s *Scraper) flowFetch(ctx context.Context, page *url.URL, r js.Request) (js.Response, error) {
+	target, err := page.Parse(r.URL)
+	if err != nil {
+		return js.Response{}, err
+	}
+	var body io.Reader
+	if r.Body != "" {
+		body = strings.NewReader(r.Body)
+	}
+	// The flow endpoints answer a wrong answer with a 403 of their own, which
+	// must not set off a session refresh.
+	ctx = context.WithValue(ctx, in403RetryKey{}, true)
+	req, err := http.NewRequestWithContext(ctx, r.Method, target.String(), body)
+	if err != nil {
+		return js.Response{}, err
+	}
+	for name, value := range r.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Referer", page.String())
+	if r.Script {
+		req.Header.Set("Accept", "*/*")
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return js.Response{}, err
+	}
+	defer resp.Body.Close()
+	data, err := s.readAll(resp.Body)
+	if err != nil {
+		return js.Response{}, err
+	}
+	return js.Response{Status: resp.StatusCode, Body: string(data)}, nil
+}
+
+// pageScripts returns the scripts of a challenge page in document order, and
+// the elements with an id that its scripts may look up.
+func pageScripts(body []byte) ([]js.Script, map[string]js.Element) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil
+	}
+
+	var scripts []js.Script
+	elements := make(map[string]js.Element)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.DataAtom == atom.Script:
+				if typ := attr(n, "type"); typ == "" || strings.Contains(typ, "javascript") {
+					if src := attr(n, "src"); src != "" {
+						scripts = append(scripts, js.Script{Src: src})
+					} else {
+						scripts = append(scripts, js.Script{Text: textOf(n)})
+					}
+				}
+			case attr(n, "id") != "":
+				elements[attr(n, "id")] = js.Element{
+					Tag:   n.Data,
+					Name:  attr(n, "name"),
+					Value: attr(n, "value"),
+					Text:  textOf(n),
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return scripts, elements
+}
+
+// textOf returns the text content of n.
+func textOf(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
@@ -0,0 +1,143 @@
+package cloudscraper
+
+import (
+	stderrors "errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Advik-B/cloudscraper/lib/cftest"
+	"github.com/Advik-B/cloudscraper/lib/errors"
+	"github.com/Advik-B/cloudscraper/lib/stealth"
+)
+
+// newTestScraper returns a scraper without stealth delays or logging, so
+// tests against a cftest.Server run quickly and quietly.
+func newTestScraper(t *testing.T, opts ...ScraperOption) *Scraper {
+	t.Helper()
+	opts = append([]ScraperOption{
+		WithStealth(stealth.Options{Enabled: false}),
+		WithLogger(log.New(io.Discard, "", 0)),
+	}, opts...)
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+// getOrigin fetches url and checks that the origin's page came back.
+func getOrigin(t *testing.T, s *Scraper, url string) {
+	t.Helper()
+	resp, err := s.Get(url)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "origin GET") {
+		t.Fatalf("got status %d and body %q, want the origin page", resp.StatusCode, body)
+	}
+}
+
+func TestSolveChallenges(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     cftest.Config
+		captcha bool
+		solved  int
+	}{
+		{name: "v1", cfg: cftest.Config{Challenge: cftest.V1}, solved: 1},
+		{name: "v2", cfg: cftest.Config{Challenge: cftest.V2}, solved: 1},
+		{name: "turnstile", cfg: cftest.Config{Challenge: cftest.Turnstile}, captcha: true, solved: 1},
+		{name: "managed", cfg: cftest.Config{Challenge: cftest.Managed}, solved: 1},
+		{name: "chain", cfg: cftest.Config{Challenge: cftest.V2, Then: []cftest.Kind{cftest.Turnstile}}, captcha: true, solved: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv := cftest.NewServer(tt.cfg)
+			defer srv.Close()
+
+			var opts []ScraperOption
+			solver := &cftest.StaticSolver{Token: srv.CaptchaToken()}
+			if tt.captcha {
+				opts = append(opts, WithCaptchaSolver(solver))
+			}
+			s := newTestScraper(t, opts...)
+
+			getOrigin(t, s, srv.URL+"/page")
+			stats := srv.Stats()
+			if stats.Solved != tt.solved || stats.Failed != 0 {
+				t.Errorf("solved %d and failed %d challenges, want %d and 0", stats.Solved, stats.Failed, tt.solved)
+			}
+			if tt.cfg.Challenge == cftest.Managed && stats.Flow == 0 {
+				t.Error("managed challenge solved without going through the orchestrate flow")
+			}
+			u, _ := url.Parse(srv.URL)
+			if s.Clearance(u.Hostname()) == nil {
+				t.Error("no clearance tracked after the solve")
+			}
+
+			// The clearance carries the next request straight to the origin.
+			getOrigin(t, s, srv.URL+"/again")
+			if got := srv.Stats(); got.Challenges != stats.Challenges {
+				t.Errorf("cleared request was challenged again: %d challenges, want %d", got.Challenges, stats.Challenges)
+			}
+		})
+	}
+}
+
+func TestBlockPage(t *testing.T) {
+	srv := cftest.NewServer(cftest.Config{Challenge: cftest.None, Block: 1020})
+	defer srv.Close()
+	s := newTestScraper(t)
+
+	_, err := s.Get(srv.URL)
+	var block *errors.BlockError
+	if !stderrors.As(err, &block) || block.Code != 1020 {
+		t.Fatalf("got error %v, want a 1020 BlockError", err)
+	}
+	if !stderrors.Is(err, errors.ErrAccessDenied) {
+		t.Errorf("error %v does not match ErrAccessDenied", err)
+	}
+	// Without a proxy to move to, a firewall block is not retried.
+	if got := srv.Stats().Blocked; got != 1 {
+		t.Errorf("server served %d block pages, want 1", got)
+	}
+}
+
+func TestForbiddenRefreshesSession(t *testing.T) {
+	srv := cftest.NewServer(cftest.Config{Challenge: cftest.None, Forbidden: 1})
+	defer srv.Close()
+	s := newTestScraper(t)
+
+	getOrigin(t, s, srv.URL+"/page")
+	if got := srv.Stats().Forbidden; got != 1 {
+		t.Errorf("server served %d 403s, want 1", got)
+	}
+}
+
+func TestRejectedCaptchaIsNotResubmitted(t *testing.T) {
+	srv := cftest.NewServer(cftest.Config{Challenge: cftest.Turnstile})
+	defer srv.Close()
+	solver := &cftest.StaticSolver{Token: "wrong-token"}
+	s := newTestScraper(t, WithCaptchaSolver(solver), WithMaxRetries(0))
+
+	_, err := s.Get(srv.URL)
+	var ce *errors.ChallengeError
+	if !stderrors.As(err, &ce) || ce.Stage != errors.StageVerify || !stderrors.Is(err, errors.ErrChallengeRejected) {
+		t.Fatalf("got error %v, want a rejected ChallengeError at the verify stage", err)
+	}
+	// The 403 rejecting the answer must not set off a session refresh,
+	// whose warm-up request would be challenged and solved again.
+	if stats := srv.Stats(); stats.Submissions != 1 || solver.Calls() != 1 {
+		t.Errorf("answer submitted %d times with %d solver calls, want 1 and 1", stats.Submissions, solver.Calls())
+	}
+}
@@ -1,40 +1,61 @@
 package cloudscraper
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"regexp"
 
-	"github.com/Advik-B/cloudscraper/lib/errors"
 	"github.com/Advik-B/cloudscraper/lib/js"
 )
 
-var (
-	jsV1ChallengeRegex = regexp.MustCompile(`setTimeout\(function\(\){\s+(var s,t,o,p,b,r,e,a,k,i,n,g,f.+?a\.value =.+?)\r?\n`)
-	jsV1PassRegex      = regexp.MustCompile(`a\.value = (.+?)\.toFixed\(10\)`)
-)
+// jsV1ChallengeRegex extracts the challenge script, from its variable
+// declarations up to the statement that fills in the answer.
+var jsV1ChallengeRegex = regexp.MustCompile(`(?s)(var s,t,o,p,b,r,e,a,k,i,n,g,f\b.+?a\.value\s*=[^;]+;)`)
+
+// v1Shim is the DOM the v1 challenge script runs against. Variants of the
+// script read the innerHTML of hidden elements, such as the cf-dn- div named
+// by k, so the page's elements are made available by id. It also fills in
+// the built-ins the script's obfuscation relies on where an engine lacks them.
+const v1Shim = `
+var __elements = %s;
+var __cache = {};
+var document = {
+    getElementById: function(id) {
+        if (!__cache[id]) {
+            var el = __elements[id] || {};
+            __cache[id] = { id: id, value: el.value || "", innerHTML: el.text || "", style: {}, action: "", submit: function() {} };
+        }
+        return __cache[id];
+    },
+    createElement: function(tag) {
+        return { firstChild: { href: "https://" + %s + "/" } };
+    }
+};
+var location = { hash: "" };
+if (!String.prototype.italics) String.prototype.italics = function() { return "<i>" + this + "</i>"; };
+if (!Array.prototype.fill) Array.prototype.fill = function(v) { for (var i = 0; i < this.length; i++) this[i] = v; return this; };
+if (String.name !== "String") { try { Object.defineProperty(String, "name", { value: "String" }); } catch (e) {} }
+`
 
 // solveV1Logic prepares and executes the v1 JS challenge using the configured engine.
-func solveV1Logic(body, domain string, engine js.Engine) (string, error) {
+func solveV1Logic(ctx context.Context, body, domain string, engine js.Engine) (string, error) {
 	matches := jsV1ChallengeRegex.FindStringSubmatch(body)
 	if len(matches) < 2 {
-		return "", fmt.Errorf("could not find Cloudflare v1 JS challenge script: %w", errors.ErrChallenge)
+		return "", extractError("v1", "challenge script")
 	}
-	challengeScript := matches[1]
 
-	passMatches := jsV1PassRegex.FindStringSubmatch(challengeScript)
-	if len(passMatches) < 2 {
-		return "", fmt.Errorf("could not find JS v1 pass expression: %w", errors.ErrChallenge)
+	_, elements := pageScripts([]byte(body))
+	elementsJSON, err := json.Marshal(elements)
+	if err != nil {
+		return "", err
 	}
-	// finalExpression is the core calculation, e.g., `(+((!![]+!![]...))) + t.length`
-	finalExpression := passMatches[1]
-
-	// Create a self-contained script that can be executed by any JS runtime.
-	// It prints the final answer to stdout, which is captured by the engine.
-	fullScript := fmt.Sprintf(`
-        var t = '%s';
-        var result = (%s).toFixed(10);
-        console.log(result);
-    `, domain, finalExpression)
-
-	return engine.Run(fullScript)
+	domainJSON, _ := json.Marshal(domain)
+
+	// Run the challenge script in a function of its own, as the page does, so
+	// that its eval calls see its variables, and print the answer it computes.
+	fullScript := fmt.Sprintf(v1Shim, elementsJSON, domainJSON) +
+		"(function() {\n" + matches[1] + "\nconsole.log(String(a.value));\n})();\n"
+
+	return engine.Run(ctx, fullScript)
 }
@@ -0,0 +1,43 @@
+package cloudscraper
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/Advik-B/cloudscraper/lib/cftest"
+	"github.com/Advik-B/cloudscraper/lib/js"
+)
+
+// TestV1Fixtures runs every page of the v1 corpus through the solver and
+// checks its answer against the fixture index. When Node.js is installed,
+// the pages are also run through it, so the recorded answers are checked
+// against a second engine.
+func TestV1Fixtures(t *testing.T) {
+	engines := map[string]js.Engine{"otto": js.NewOttoEngine()}
+	if _, err := exec.LookPath(string(js.Node)); err == nil {
+		node, err := js.NewExternalEngine(string(js.Node))
+		if err != nil {
+			t.Fatalf("NewExternalEngine: %v", err)
+		}
+		engines["node"] = node
+	}
+
+	fixtures := cftest.V1Fixtures()
+	if len(fixtures) == 0 {
+		t.Fatal("empty v1 fixture corpus")
+	}
+	for _, f := range fixtures {
+		for name, engine := range engines {
+			t.Run(f.Name+"/"+name, func(t *testing.T) {
+				got, err := solveV1Logic(context.Background(), f.Page, f.Host, engine)
+				if err != nil {
+					t.Fatalf("solveV1Logic: %v", err)
+				}
+				if got != f.Answer {
+					t.Errorf("answer for %s is %s, want %s", f.Host, got, f.Answer)
+				}
+			})
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package cloudscraper
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"regexp"
 	"strings"
@@ -13,23 +13,23 @@ import (
 var v2ScriptRegex = regexp.MustCompile(`(?s)<script[^>]*>(.*?window\._cf_chl_opt.*?)<\/script>`)
 
 // solveV2Logic solves modern v2/v3 challenges by delegating to the appropriate JS engine implementation.
-func solveV2Logic(body, domain string, engine js.Engine, logger *log.Logger) (string, error) {
+func solveV2Logic(ctx context.Context, body, domain string, engine js.Engine, logger *log.Logger) (string, error) {
 	scriptMatches := v2ScriptRegex.FindAllStringSubmatch(body, -1)
 	if len(scriptMatches) == 0 {
-		return "", fmt.Errorf("could not find modern JS challenge scripts")
+		return "", extractError("v2", "challenge scripts")
 	}
 
 	// Use a special synchronous path for Otto, which can't handle async setTimeout.
 	if ottoEngine, ok := engine.(*js.OttoEngine); ok {
-		return ottoEngine.SolveV2Challenge(body, domain, scriptMatches, logger)
+		return ottoEngine.SolveV2Challenge(ctx, body, domain, scriptMatches, logger)
 	}
 
 	// Use a modern asynchronous path for external runtimes (node, deno, bun).
-	return solveV2WithExternal(domain, scriptMatches, engine)
+	return solveV2WithExternal(ctx, domain, scriptMatches, engine)
 }
 
 // solveV2WithExternal builds a full script with shims and an async callback to solve the challenge.
-func solveV2WithExternal(domain string, scriptMatches [][]string, engine js.Engine) (string, error) {
+func solveV2WithExternal(ctx context.Context, domain string, scriptMatches [][]string, engine js.Engine) (string, error) {
 	// This DOM shim is required for the challenge script to run in a non-browser environment.
 	atobImpl := `
         var chars = 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=';
@@ -92,5 +92,5 @@ func solveV2WithExternal(domain string, scriptMatches [][]string, engine js.Engi
     `
 	fullScript.WriteString(answerExtractor)
 
-	return engine.Run(fullScript.String())
+	return engine.Run(ctx, fullScript.String())
 }
@@ -0,0 +1,76 @@
+package cloudscraper
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+)
+
+// Text reads resp's body and returns it as UTF-8. The charset is taken from
+// a byte order mark, the Content-Type header, or a <meta charset> or
+// http-equiv Content-Type tag in the first 1024 bytes, in that order, as
+// browsers do. A body with none of these is taken as UTF-8 if it is valid
+// UTF-8, and as windows-1252 otherwise. Text closes the body and replaces it
+// with an in-memory copy of the original bytes, so it can be read again.
+func (s *Scraper) Text(resp *http.Response) (string, error) {
+	body, err := s.bufferBody(resp)
+	if err != nil {
+		return "", err
+	}
+	text := toUTF8(resp.Header.Get("Content-Type"), body)
+	return strings.TrimPrefix(string(text), "\uFEFF"), nil
+}
+
+// toUTF8 converts body, served with the given Content-Type, to UTF-8. A
+// body already in UTF-8, or one that cannot be converted, is returned as it
+// is.
+func toUTF8(contentType string, body []byte) []byte {
+	e, name, certain := charset.DetermineEncoding(body, contentType)
+	if !certain && name == "windows-1252" && utf8.Valid(body) {
+		// windows-1252 is only the HTML default. DetermineEncoding looks at
+		// the first 1024 bytes; a body that is valid UTF-8 throughout is
+		// almost certainly UTF-8.
+		return body
+	}
+	if name == "utf-8" {
+		return body
+	}
+	text, _, err := transform.Bytes(e.NewDecoder(), body)
+	if err != nil {
+		return body
+	}
+	return text
+}
+
+// detectionText returns body as challenge and block page detection sees it:
+// converted to UTF-8 if resp could be such a page, and left alone
+// otherwise. Only text responses with a status of 400 or above, or a
+// Cf-Mitigated header, are converted, so that no other body, a binary one
+// above all, is copied just to be looked at.
+func detectionText(resp *http.Response, body []byte) []byte {
+	if resp.StatusCode < 400 && resp.Header.Get("Cf-Mitigated") == "" {
+		return body
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !isTextType(contentType) {
+		return body
+	}
+	return toUTF8(contentType, body)
+}
+
+// isTextType reports whether a Content-Type, which may be empty, is one a
+// challenge or block page could be served with.
+func isTextType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/xhtml+xml"
+}
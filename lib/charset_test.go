@@ -0,0 +1,88 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func encode(t *testing.T, e encoding.Encoding, s string) []byte {
+	t.Helper()
+	b, err := e.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatalf("encoding %q: %v", s, err)
+	}
+	return b
+}
+
+func TestText(t *testing.T) {
+	utf16 := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        string
+	}{
+		{"header", "text/html; charset=Shift_JIS", encode(t, japanese.ShiftJIS, "<p>こんにちは</p>"), "<p>こんにちは</p>"},
+		{"meta charset", "text/html", encode(t, simplifiedchinese.GBK, `<meta charset="gbk"><p>你好</p>`), `<meta charset="gbk"><p>你好</p>`},
+		{"http-equiv", "text/html",
+			encode(t, charmap.Windows1251, `<meta http-equiv="Content-Type" content="text/html; charset=windows-1251"><p>Привет</p>`),
+			`<meta http-equiv="Content-Type" content="text/html; charset=windows-1251"><p>Привет</p>`},
+		{"bom", "text/html", encode(t, utf16, "<p>héllo</p>"), "<p>héllo</p>"},
+		{"undeclared utf-8", "application/json", []byte(`{"name":"ünïcödé"}`), `{"name":"ünïcödé"}`},
+		{"undeclared legacy", "text/plain", []byte("caf\xe9"), "café"},
+	}
+	s := newTestScraper(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{"Content-Type": {tt.contentType}}, Body: io.NopCloser(bytes.NewReader(tt.body))}
+			got, err := s.Text(resp)
+			if err != nil {
+				t.Fatalf("Text: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Text = %q, want %q", got, tt.want)
+			}
+			// The body is left to be read again, as sent.
+			if raw, _ := io.ReadAll(resp.Body); !bytes.Equal(raw, tt.body) {
+				t.Error("body not restored after Text")
+			}
+		})
+	}
+}
+
+func TestDetectionText(t *testing.T) {
+	// With a byte order mark, the page's charset is known without a header.
+	page := encode(t, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), "<title>Just a moment...</title>")
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		converted   bool
+	}{
+		{"challenge page", http.StatusServiceUnavailable, "text/html; charset=utf-16le", true},
+		{"no content type", http.StatusForbidden, "", true},
+		{"ok page", http.StatusOK, "text/html; charset=utf-16le", false},
+		{"binary error", http.StatusNotFound, "application/octet-stream", false},
+		{"image", http.StatusForbidden, "image/png", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.contentType != "" {
+				resp.Header.Set("Content-Type", tt.contentType)
+			}
+			got := detectionText(resp, page)
+			if converted := !bytes.Equal(got, page); converted != tt.converted {
+				t.Errorf("converted = %v, want %v", converted, tt.converted)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package cloudscraper
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/clearance"
+	"github.com/Advik-B/cloudscraper/lib/cookies"
+	"github.com/Advik-B/cloudscraper/lib/errors"
+	"github.com/Advik-B/cloudscraper/lib/transport"
+)
+
+// clearanceKey returns the identity the sent request presented to Cloudflare:
+// its host, User-Agent and proxy egress.
+func (s *Scraper) clearanceKey(ctx context.Context, sent *http.Request) clearance.Key {
+	return clearance.Key{
+		Domain:    sent.URL.Hostname(),
+		UserAgent: sent.Header.Get("User-Agent"),
+		Proxy:     clearance.ProxyEgress(transport.ProxyFromContext(ctx)),
+	}
+}
+
+// restoreClearance copies a stored clearance for key into the jar and reports
+// whether it did. A clearance that was already sent with the challenged
+// request has been revoked, so it is removed from the store instead.
+func (s *Scraper) restoreClearance(ctx context.Context, key clearance.Key, challenged *http.Response) bool {
+	if s.ClearanceStore == nil {
+		return false
+	}
+	c, err := s.ClearanceStore.Get(ctx, key)
+	if err != nil {
+		s.logger.Printf("Warning: clearance store lookup failed: %v\n", err)
+		return false
+	}
+	if c == nil || c.Expired(time.Now().Add(s.renewalMargin(c))) {
+		return false
+	}
+
+	if sent, err := challenged.Request.Cookie(clearance.CookieName); err == nil && sent.Value == c.Cookie.Value {
+		s.logger.Printf("Stored clearance for %s was rejected, discarding it\n", key.Domain)
+		if err := s.ClearanceStore.Delete(ctx, key); err != nil {
+			s.logger.Printf("Warning: clearance store delete failed: %v\n", err)
+		}
+		return false
+	}
+
+	s.jar.Import([]cookies.Entry{c.Cookie})
+	s.trackClearance(key.Domain, c)
+	return true
+}
+
+// verifyClearance checks that the solve of the challenge in resp, detected
+// by the handler registered as name, left a cf_clearance cookie for resp's
+// URL in the jar. solved is the response the solve ended with, which is
+// closed if the check fails.
+func (s *Scraper) verifyClearance(name string, resp, solved *http.Response) error {
+	if _, ok := s.jar.Lookup(resp.Request.URL, clearance.CookieName); ok {
+		return nil
+	}
+	solved.Body.Close()
+	return &errors.ChallengeError{
+		Kind:       name,
+		Stage:      errors.StageVerify,
+		URL:        resp.Request.URL.String(),
+		StatusCode: solved.StatusCode,
+		RayID:      solved.Header.Get("Cf-Ray"),
+		Err:        errors.ErrNoClearance,
+	}
+}
+
+// publishClearance records the cf_clearance cookie the jar now holds for u,
+// issued to key, so that Scraper.Clearance reports it and it is renewed
+// before it expires, and shares it through the ClearanceStore.
+func (s *Scraper) publishClearance(ctx context.Context, key clearance.Key, u *url.URL) {
+	entry, ok := s.jar.Lookup(u, clearance.CookieName)
+	if !ok {
+		return
+	}
+	c := &clearance.Clearance{
+		Cookie:    entry,
+		UserAgent: key.UserAgent,
+		Proxy:     key.Proxy,
+		IssuedAt:  time.Now(),
+	}
+	s.trackClearance(key.Domain, c)
+	if s.ClearanceStore == nil {
+		return
+	}
+	if err := s.ClearanceStore.Put(ctx, key, c); err != nil {
+		s.logger.Printf("Warning: failed to publish clearance: %v\n", err)
+	}
+}
+
+// Clearance returns the cf_clearance cookie the scraper holds for domain,
+// along with its expiry and the User-Agent and proxy it is bound to. It
+// returns nil if the scraper has not solved or restored a challenge on
+// domain, or the cookie has expired.
+func (s *Scraper) Clearance(domain string) *clearance.Clearance {
+	s.clearanceMu.Lock()
+	defer s.clearanceMu.Unlock()
+	c, ok := s.clearances[strings.ToLower(domain)]
+	if !ok || c.Expired(time.Now()) {
+		return nil
+	}
+	clone := *c
+	return &clone
+}
+
+func (s *Scraper) trackClearance(domain string, c *clearance.Clearance) {
+	s.clearanceMu.Lock()
+	defer s.clearanceMu.Unlock()
+	if s.clearances == nil {
+		s.clearances = make(map[string]*clearance.Clearance)
+	}
+	s.clearances[strings.ToLower(domain)] = c
+}
+
+// maybeRefreshClearance drops the cf_clearance cookie for u's host once it
+// is within its renewal margin of expiring, so that the request about to be
+// sent is challenged and solved now rather than the cookie lapsing halfway
+// through later work.
+func (s *Scraper) maybeRefreshClearance(u *url.URL) {
+	host := strings.ToLower(u.Hostname())
+	s.clearanceMu.Lock()
+	c, ok := s.clearances[host]
+	due := ok && !c.Expires().IsZero() && time.Until(c.Expires()) < s.renewalMargin(c)
+	if due {
+		delete(s.clearances, host)
+	}
+	s.clearanceMu.Unlock()
+
+	if due {
+		s.logger.Printf("Clearance for %s expires at %s, renewing it\n", host, c.Expires().Format(time.RFC3339))
+		s.jar.Remove([]cookies.Entry{c.Cookie})
+	}
+}
+
+// renewalMargin returns how long before it expires c is renewed:
+// Options.ClearanceRefreshMargin, but no more than a quarter of the
+// cookie's lifetime, so that a short-lived cookie is still used.
+func (s *Scraper) renewalMargin(c *clearance.Clearance) time.Duration {
+	margin := s.opts.ClearanceRefreshMargin
+	if c.Expires().IsZero() || margin <= 0 {
+		return 0
+	}
+	if lifetime := c.Expires().Sub(c.IssuedAt); margin > lifetime/4 {
+		margin = lifetime / 4
+	}
+	return margin
+}
@@ -0,0 +1,64 @@
+// Package clearance shares Cloudflare clearance cookies between scrapers, so
+// that a challenge solved by one process is not solved again by the next.
+package clearance
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/cookies"
+)
+
+// CookieName is the name of the cookie Cloudflare issues once a challenge is passed.
+const CookieName = "cf_clearance"
+
+// Key identifies who a clearance was issued to. Cloudflare binds cf_clearance
+// to the User-Agent, and often to the client IP, that solved the challenge, so
+// a clearance is only reused by a scraper presenting the same identity.
+type Key struct {
+	// Domain is the host the challenge was served on.
+	Domain string
+	// UserAgent is the User-Agent the challenge was solved with.
+	UserAgent string
+	// Proxy is the proxy egress the challenge was solved through, as
+	// returned by ProxyEgress, or "" for a direct connection.
+	Proxy string
+}
+
+// ProxyEgress returns the Key.Proxy value for proxyURL. Credentials are
+// dropped, so that they are never written to a store.
+func ProxyEgress(proxyURL *url.URL) string {
+	if proxyURL == nil {
+		return ""
+	}
+	return proxyURL.Scheme + "://" + proxyURL.Host
+}
+
+// Clearance is a cf_clearance cookie and the identity it was issued under.
+type Clearance struct {
+	Cookie    cookies.Entry `json:"cookie"`
+	UserAgent string        `json:"userAgent"`
+	Proxy     string        `json:"proxy,omitempty"`
+	IssuedAt  time.Time     `json:"issuedAt"`
+}
+
+// Expires returns when the clearance cookie expires, or the zero time if it
+// lasts for the session.
+func (c *Clearance) Expires() time.Time {
+	return c.Cookie.Expires
+}
+
+// Expired reports whether the clearance has expired at now.
+func (c *Clearance) Expired(now time.Time) bool {
+	return c.Cookie.Expired(now)
+}
+
+// Store keeps clearances so they can be shared between scrapers. Get returns
+// nil without an error when the store holds no unexpired clearance for key.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	Get(ctx context.Context, key Key) (*Clearance, error)
+	Put(ctx context.Context, key Key, c *Clearance) error
+	Delete(ctx context.Context, key Key) error
+}
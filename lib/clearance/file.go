@@ -0,0 +1,96 @@
+package clearance
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStore is a Store backed by a directory, for sharing clearances between
+// processes on one machine. Each key is kept in its own file, which is
+// replaced atomically, so concurrent writers never corrupt each other and
+// readers never see a partial write.
+type FileStore struct {
+	dir string
+}
+
+// fileRecord is the on-disk form of a clearance.
+type fileRecord struct {
+	Domain string `json:"domain"`
+	Clearance
+}
+
+// NewFileStore creates a FileStore in dir, creating the directory if needed.
+// The directory and its files are accessible only by their owner, since
+// clearances are session credentials.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create clearance store: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Get implements Store.
+func (f *FileStore) Get(_ context.Context, key Key) (*Clearance, error) {
+	data, err := os.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var rec fileRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("invalid clearance file %s: %w", f.path(key), err)
+	}
+	if rec.Domain != key.Domain || rec.UserAgent != key.UserAgent || rec.Proxy != key.Proxy {
+		return nil, nil // a hash collision, however unlikely
+	}
+	if rec.Expired(time.Now()) {
+		os.Remove(f.path(key))
+		return nil, nil
+	}
+	return &rec.Clearance, nil
+}
+
+// Put implements Store.
+func (f *FileStore) Put(_ context.Context, key Key, c *Clearance) error {
+	rec := fileRecord{Domain: key.Domain, Clearance: *c}
+	rec.UserAgent, rec.Proxy = key.UserAgent, key.Proxy
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(f.dir, ".clearance-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(key))
+}
+
+// Delete implements Store.
+func (f *FileStore) Delete(_ context.Context, key Key) error {
+	if err := os.Remove(f.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *FileStore) path(key Key) string {
+	sum := sha256.Sum256([]byte(key.Domain + "\x00" + key.UserAgent + "\x00" + key.Proxy))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:16])+".json")
+}
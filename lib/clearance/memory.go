@@ -0,0 +1,49 @@
+package clearance
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that shares clearances between scrapers in one process.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[Key]Clearance
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[Key]Clearance)}
+}
+
+// Get implements Store.
+func (m *MemoryStore) Get(_ context.Context, key Key) (*Clearance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if c.Expired(time.Now()) {
+		delete(m.entries, key)
+		return nil, nil
+	}
+	return &c, nil
+}
+
+// Put implements Store.
+func (m *MemoryStore) Put(_ context.Context, key Key, c *Clearance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = *c
+	return nil
+}
+
+// Delete implements Store.
+func (m *MemoryStore) Delete(_ context.Context, key Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
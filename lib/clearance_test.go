@@ -0,0 +1,132 @@
+package cloudscraper
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/cftest"
+	"github.com/Advik-B/cloudscraper/lib/clearance"
+	"github.com/Advik-B/cloudscraper/lib/cookies"
+	"github.com/Advik-B/cloudscraper/lib/proxy"
+	"github.com/Advik-B/cloudscraper/lib/user_agent"
+)
+
+const sharedAgent = "Mozilla/5.0 (X11; Linux x86_64) cftest/1.0"
+
+func TestSharedClearance(t *testing.T) {
+	srv := cftest.NewServer(cftest.Config{Challenge: cftest.Managed})
+	defer srv.Close()
+	store, err := clearance.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := mustParse(t, srv.URL).Hostname()
+	scraper := func(agent string, opts ...ScraperOption) *Scraper {
+		return newTestScraper(t, append([]ScraperOption{
+			WithClearanceStore(store),
+			WithBrowser(useragent.Config{Custom: agent}),
+		}, opts...)...)
+	}
+	wantSolved := func(step string, n int) {
+		t.Helper()
+		if stats := srv.Stats(); stats.Solved != n || stats.Failed != 0 {
+			t.Fatalf("%s: solved %d and failed %d challenges, want %d and 0", step, stats.Solved, stats.Failed, n)
+		}
+	}
+
+	first := scraper(sharedAgent)
+	getOrigin(t, first, srv.URL+"/page")
+	wantSolved("first scraper", 1)
+
+	second := scraper(sharedAgent)
+	getOrigin(t, second, srv.URL+"/page")
+	wantSolved("second scraper", 1)
+	if got, want := second.Clearance(host), first.Clearance(host); got == nil || got.Cookie.Value != want.Cookie.Value {
+		t.Errorf("second scraper holds %+v, want the first's clearance %+v", got, want)
+	}
+
+	// A clearance is bound to the User-Agent and egress it was issued to.
+	getOrigin(t, scraper(sharedAgent+" other"), srv.URL+"/page")
+	wantSolved("another User-Agent", 2)
+	proxied := scraper(sharedAgent, WithProxies([]string{srv.URL}, proxy.Sequential, time.Minute))
+	getOrigin(t, proxied, srv.URL+"/page")
+	wantSolved("another proxy egress", 3)
+	if c := proxied.Clearance(host); c == nil || c.Proxy != clearance.ProxyEgress(mustParse(t, srv.URL)) {
+		t.Errorf("proxied clearance %+v not bound to the proxy", c)
+	}
+
+	// A revoked clearance is discarded and replaced by a fresh solve.
+	srv.Revoke()
+	third := scraper(sharedAgent)
+	getOrigin(t, third, srv.URL+"/page")
+	wantSolved("after revocation", 4)
+	stored, err := store.Get(context.Background(), clearance.Key{Domain: host, UserAgent: sharedAgent})
+	if err != nil || stored == nil || stored.Cookie.Value != third.Clearance(host).Cookie.Value {
+		t.Errorf("store holds %+v (%v), want the renewed clearance", stored, err)
+	}
+}
+
+func TestStoredClearanceNearExpiry(t *testing.T) {
+	srv := cftest.NewServer(cftest.Config{Challenge: cftest.Managed})
+	defer srv.Close()
+	host := mustParse(t, srv.URL).Hostname()
+
+	// A clearance within its renewal margin is not restored, even if the
+	// server would still accept it.
+	seed := newTestScraper(t, WithBrowser(useragent.Config{Custom: sharedAgent}))
+	getOrigin(t, seed, srv.URL+"/page")
+	c := seed.Clearance(host)
+	c.Cookie.Expires = time.Now().Add(30 * time.Second)
+	c.IssuedAt = time.Now().Add(-time.Hour)
+	store := clearance.NewMemoryStore()
+	store.Put(context.Background(), clearance.Key{Domain: host, UserAgent: sharedAgent}, c)
+
+	s := newTestScraper(t, WithClearanceStore(store), WithBrowser(useragent.Config{Custom: sharedAgent}))
+	getOrigin(t, s, srv.URL+"/page")
+	if stats := srv.Stats(); stats.Solved != 2 {
+		t.Errorf("solved %d challenges, want 2: the expiring clearance was restored", stats.Solved)
+	}
+}
+
+func TestClearanceRenewal(t *testing.T) {
+	u := &url.URL{Scheme: "https", Host: "example.com", Path: "/"}
+	now := time.Now()
+	tests := []struct {
+		name    string
+		margin  time.Duration
+		issued  time.Duration // relative to now
+		expires time.Duration // relative to now
+		renew   bool
+	}{
+		{"fresh", 2 * time.Minute, -time.Hour, 30 * time.Minute, false},
+		{"within margin", 2 * time.Minute, -time.Hour, time.Minute, true},
+		{"short-lived outside capped margin", 2 * time.Minute, -3 * time.Minute, 90 * time.Second, false},
+		{"short-lived within capped margin", 2 * time.Minute, -3 * time.Minute, 30 * time.Second, true},
+		{"renewal disabled", 0, -time.Hour, time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScraper(t, WithClearanceRefresh(tt.margin))
+			entry := cookies.Entry{Name: clearance.CookieName, Value: "v", Domain: "example.com", Path: "/", HostOnly: true, Expires: now.Add(tt.expires)}
+			s.jar.Import([]cookies.Entry{entry})
+			s.trackClearance("example.com", &clearance.Clearance{Cookie: entry, IssuedAt: now.Add(tt.issued)})
+
+			s.maybeRefreshClearance(u)
+			_, kept := s.jar.Lookup(u, clearance.CookieName)
+			if kept == tt.renew || (s.Clearance("example.com") == nil) != tt.renew {
+				t.Errorf("cookie kept = %v, want renewal = %v", kept, tt.renew)
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
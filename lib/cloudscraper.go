@@ -1,18 +1,23 @@
 package cloudscraper
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
+	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/Advik-B/cloudscraper/lib/captcha"
+	"github.com/Advik-B/cloudscraper/lib/clearance"
+	"github.com/Advik-B/cloudscraper/lib/cookies"
 	"github.com/Advik-B/cloudscraper/lib/errors"
 	"github.com/Advik-B/cloudscraper/lib/js"
 	"github.com/Advik-B/cloudscraper/lib/proxy"
@@ -20,33 +25,57 @@ import (
 	"github.com/Advik-B/cloudscraper/lib/transport"
 	useragent "github.com/Advik-B/cloudscraper/lib/user_agent"
 
-	"github.com/andybalholm/brotli"
 	"golang.org/x/net/publicsuffix"
 )
 
 // Scraper is the main struct for making requests.
+//
+// A Scraper is safe for concurrent use by multiple goroutines and is meant to
+// be shared, like an http.Client. Session refreshes swap the browser identity
+// and TLS profile atomically: a request runs start to finish under the
+// identity it began with, and concurrent 403s trigger a single refresh.
+// Likewise, requests challenged on the same host at once share one solve. The
+// exported fields may be set after New but must not be modified once
+// requests are in flight; UserAgent in particular is replaced on refresh, so
+// read it only while the scraper is idle.
 type Scraper struct {
-	client *http.Client
-	opts   Options
-	logger *log.Logger
-
-	UserAgent     *useragent.Agent
-	CaptchaSolver captcha.Solver
-	ProxyManager  *proxy.Manager
-	StealthMode   *stealth.Mode
-	jsEngine      js.Engine
-
-	mu               sync.Mutex
+	client       *http.Client
+	streamClient *http.Client // client without the overall timeout, for streamed requests
+	jar          *cookies.Jar
+	opts         Options
+	logger       *log.Logger
+
+	UserAgent      *useragent.Agent
+	CaptchaSolver  captcha.Solver
+	ClearanceStore clearance.Store
+	Challenges     *ChallengeRegistry
+	ProxyManager   *proxy.Manager
+	StealthMode    *stealth.Mode
+	jsEngine       js.Engine
+
+	mu               sync.Mutex // guards UserAgent, sessionStartTime and sessionGen
 	sessionStartTime time.Time
+	sessionGen       uint64
 	requestCount     int32
-	last403Time      time.Time
-	in403Retry       bool
+
+	flightMu sync.Mutex
+	flights  map[string]*challengeFlight // challenge solves in progress, by host
+
+	clearanceMu sync.Mutex
+	clearances  map[string]*clearance.Clearance // clearances obtained or restored, by host
 }
 
 // New creates a new Scraper instance with the given options.
 func New(opts ...ScraperOption) (*Scraper, error) {
 	options := Options{
 		MaxRetries:             3,
+		RetryPolicy:            DefaultRetryPolicy{},
+		MaxRedirects:           10,
+		ChallengeTimeout:       2 * time.Minute,
+		ChallengeWaitTimeout:   2 * time.Minute,
+		MaxChallengeStages:     8,
+		MaxChallengeRepeats:    2,
+		ClearanceRefreshMargin: 2 * time.Minute,
 		AutoRefreshOn403:       true,
 		AutoRefreshSession:     true,
 		SessionRefreshInterval: 1 * time.Hour,
@@ -65,9 +94,11 @@ func New(opts ...ScraperOption) (*Scraper, error) {
 		opt(&options)
 	}
 
-	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cookie jar: %w", err)
+	jar := cookies.New(publicsuffix.List)
+	if options.CookieFile != "" {
+		if err := jar.LoadFile(options.CookieFile); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to load cookie file: %w", err)
+		}
 	}
 
 	agent, err := useragent.New(options.Browser)
@@ -86,6 +117,10 @@ func New(opts ...ScraperOption) (*Scraper, error) {
 		}
 	}
 
+	if options.ChallengeRegistry == nil {
+		options.ChallengeRegistry = NewChallengeRegistry()
+	}
+
 	var logger *log.Logger
 	if options.Logger != nil {
 		logger = options.Logger
@@ -116,9 +151,12 @@ func New(opts ...ScraperOption) (*Scraper, error) {
 			},
 			Timeout: 30 * time.Second, // Add a default timeout
 		},
+		jar:              jar,
 		opts:             options,
 		UserAgent:        agent,
 		CaptchaSolver:    options.CaptchaSolver,
+		ClearanceStore:   options.ClearanceStore,
+		Challenges:       options.ChallengeRegistry,
 		ProxyManager:     pm,
 		StealthMode:      stealth.New(options.Stealth),
 		jsEngine:         jsEngine,
@@ -126,68 +164,224 @@ func New(opts ...ScraperOption) (*Scraper, error) {
 		sessionStartTime: time.Now(),
 	}
 
+	// Streamed bodies may take far longer to read than the client's
+	// timeout allows, so streamed requests are bounded by their context alone.
+	streamClient := *s.client
+	streamClient.Timeout = 0
+	s.streamClient = &streamClient
+
+	if options.CookieFile != "" {
+		jar.OnChange(func() {
+			if err := jar.SaveFile(options.CookieFile); err != nil {
+				s.logger.Printf("Warning: failed to save cookies: %v\n", err)
+			}
+		})
+	}
+
 	return s, nil
 }
 
+// Cookies returns the scraper's cookie jar, which can list its contents.
+func (s *Scraper) Cookies() *cookies.Jar {
+	return s.jar
+}
+
+// SaveCookies writes every cookie in the jar to path, as a Netscape
+// cookies.txt file if path ends in ".txt" and as JSON otherwise.
+func (s *Scraper) SaveCookies(path string) error {
+	return s.jar.SaveFile(path)
+}
+
+// LoadCookies adds the cookies saved in path to the jar. Both formats
+// written by SaveCookies are accepted, whatever the file's extension.
+func (s *Scraper) LoadCookies(path string) error {
+	return s.jar.LoadFile(path)
+}
+
 // Get performs a GET request.
 func (s *Scraper) Get(url string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	return s.GetContext(context.Background(), url)
+}
+
+// GetContext performs a GET request bound to ctx. Cancelling ctx aborts the
+// request and any challenge solving it triggers.
+func (s *Scraper) GetContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	return s.do(req)
+	return s.execute(req)
 }
 
 // Post performs a POST request.
 func (s *Scraper) Post(url, contentType string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest("POST", url, body)
+	return s.PostContext(context.Background(), url, contentType, body)
+}
+
+// PostContext performs a POST request bound to ctx.
+func (s *Scraper) PostContext(ctx context.Context, url, contentType string, body io.Reader) (*http.Response, error) {
+	return s.send(ctx, http.MethodPost, url, contentType, body)
+}
+
+// PostForm performs a POST request with data URL-encoded as the body.
+func (s *Scraper) PostForm(url string, data url.Values) (*http.Response, error) {
+	return s.PostFormContext(context.Background(), url, data)
+}
+
+// PostFormContext performs a POST request bound to ctx with data
+// URL-encoded as the body.
+func (s *Scraper) PostFormContext(ctx context.Context, url string, data url.Values) (*http.Response, error) {
+	return s.send(ctx, http.MethodPost, url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+}
+
+// PostJSON performs a POST request with v encoded as JSON as the body.
+func (s *Scraper) PostJSON(url string, v any) (*http.Response, error) {
+	return s.PostJSONContext(context.Background(), url, v)
+}
+
+// PostJSONContext performs a POST request bound to ctx with v encoded as
+// JSON as the body. It asks for JSON in return.
+func (s *Scraper) PostJSONContext(ctx context.Context, url string, v any) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := s.newRequest(ctx, http.MethodPost, url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	return s.execute(req)
+}
+
+// Head performs a HEAD request.
+func (s *Scraper) Head(url string) (*http.Response, error) {
+	return s.HeadContext(context.Background(), url)
+}
+
+// HeadContext performs a HEAD request bound to ctx.
+func (s *Scraper) HeadContext(ctx context.Context, url string) (*http.Response, error) {
+	return s.send(ctx, http.MethodHead, url, "", nil)
+}
+
+// Put performs a PUT request.
+func (s *Scraper) Put(url, contentType string, body io.Reader) (*http.Response, error) {
+	return s.PutContext(context.Background(), url, contentType, body)
+}
+
+// PutContext performs a PUT request bound to ctx.
+func (s *Scraper) PutContext(ctx context.Context, url, contentType string, body io.Reader) (*http.Response, error) {
+	return s.send(ctx, http.MethodPut, url, contentType, body)
+}
+
+// Patch performs a PATCH request.
+func (s *Scraper) Patch(url, contentType string, body io.Reader) (*http.Response, error) {
+	return s.PatchContext(context.Background(), url, contentType, body)
+}
+
+// PatchContext performs a PATCH request bound to ctx.
+func (s *Scraper) PatchContext(ctx context.Context, url, contentType string, body io.Reader) (*http.Response, error) {
+	return s.send(ctx, http.MethodPatch, url, contentType, body)
+}
+
+// Delete performs a DELETE request.
+func (s *Scraper) Delete(url string) (*http.Response, error) {
+	return s.DeleteContext(context.Background(), url)
+}
+
+// DeleteContext performs a DELETE request bound to ctx.
+func (s *Scraper) DeleteContext(ctx context.Context, url string) (*http.Response, error) {
+	return s.send(ctx, http.MethodDelete, url, "", nil)
+}
+
+// send performs a request bound to ctx with the given body, which may be
+// nil. Like any request, it is replayed with the same body after a
+// challenge or a 403 refresh; see makeReplayable.
+func (s *Scraper) send(ctx context.Context, method, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := s.newRequest(ctx, method, url, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	return s.execute(req)
+}
+
+func (s *Scraper) newRequest(ctx context.Context, method, url, contentType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", contentType)
-	return s.do(req)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
 }
 
-// Performs a request.
+// Send performs a request using the request's own context.
 func (s *Scraper) Send(req *http.Request) (*http.Response, error) {
-	return s.do(req)
+	return s.execute(req)
+}
+
+// DoContext performs a request bound to ctx, which replaces the request's own context.
+// The deadline and cancellation of ctx reach every stealth delay, JS engine run,
+// external runtime process and captcha solver poll made on behalf of the request.
+func (s *Scraper) DoContext(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return s.execute(req.WithContext(ctx))
 }
 
 func (s *Scraper) do(req *http.Request) (*http.Response, error) {
-	s.mu.Lock()
-	if s.shouldRefreshSession() {
-		if err := s.refreshSession(req.URL); err != nil {
-			s.logger.Printf("Warning: session refresh failed: %v\n", err)
-		}
+	ctx := req.Context()
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	s.mu.Unlock()
 
-	for key, values := range s.UserAgent.Headers {
+	s.maybeRefreshSession(ctx, req.URL)
+	s.maybeRefreshClearance(req.URL)
+
+	agent, gen := s.identity()
+	for key, values := range agent.Headers {
 		if req.Header.Get(key) == "" {
-			req.Header[key] = values
+			req.Header[key] = append([]string(nil), values...)
 		}
 	}
 
-	s.StealthMode.Apply(req, s.UserAgent.Browser)
+	if err := s.StealthMode.Apply(req, agent.Browser); err != nil {
+		return nil, err
+	}
+	if req.Response == nil && req.Header.Get("Sec-Fetch-Site") == "none" && req.Header.Get("Referer") != "" {
+		// A request with a referrer, such as a challenge form submission,
+		// comes from that page rather than from the address bar.
+		req.Header.Set("Sec-Fetch-Site", fetchSite(req))
+	}
+
+	if err := makeReplayable(req); err != nil {
+		return nil, err
+	}
 
-	var currentProxy *url.URL
+	currentProxy := transport.ProxyFromContext(ctx)
 	var err error
-	if s.ProxyManager != nil {
+	if currentProxy == nil && s.ProxyManager != nil {
 		currentProxy, err = s.ProxyManager.GetProxy()
 		if err != nil {
 			return nil, err
 		}
-		if tr, ok := s.client.Transport.(*transport.CipherSuiteTransport); ok {
-			tr.Transport.Proxy = http.ProxyURL(currentProxy)
+		if currentProxy != nil {
+			ctx = transport.WithProxy(ctx, currentProxy)
+			req = req.WithContext(ctx)
 		}
 	}
 
 	atomic.AddInt32(&s.requestCount, 1)
 
-	resp, err := s.client.Do(req)
+	// http.Client adds the jar's cookies to the request it sends. Send a copy,
+	// so that a replay of req picks up the jar's cookies as they are then.
+	client := s.client
+	if s.streaming(ctx) {
+		client = s.streamClient
+	}
+	resp, err := client.Do(req.Clone(ctx))
 	if err != nil {
 		if currentProxy != nil {
-			s.ProxyManager.ReportFailure(currentProxy)
+			s.reportProxyFailure(currentProxy)
 		}
 		return nil, err
 	}
@@ -196,69 +390,135 @@ func (s *Scraper) do(req *http.Request) (*http.Response, error) {
 		s.ProxyManager.ReportSuccess(currentProxy)
 	}
 
-	switch resp.Header.Get("Content-Encoding") {
-	case "br":
-		resp.Body = io.NopCloser(brotli.NewReader(resp.Body))
-		resp.Header.Del("Content-Encoding")
+	if err := decodeBody(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
-	resp.Body.Close()
+	bodyBytes, complete, err := s.readBody(ctx, resp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
-	resp.Body = io.NopCloser(strings.NewReader(string(bodyBytes)))
+	// Detection and solving look at the page as text, so a page in a legacy
+	// charset such as Shift_JIS is seen in UTF-8. resp.Body is left as sent.
+	bodyBytes = detectionText(resp, bodyBytes)
+
+	if block := detectBlock(resp, bodyBytes); block != nil {
+		// A block page is not cured by a session refresh; leave it to the retry policy.
+		return nil, block
+	}
 
-	if isChallengeResponse(resp, bodyBytes) {
-		s.logger.Println("Cloudflare protection detected, attempting to bypass...")
-		return s.handleChallenge(resp)
+	detection := DetectChallenge(resp, bodyBytes)
+	if name, h := s.Challenges.detect(resp, bodyBytes, detection); h != nil {
+		if !complete {
+			// Solving needs the whole page, which a challenge page is small enough for.
+			if bodyBytes, err = s.bufferBody(resp); err != nil {
+				return nil, fmt.Errorf("failed to read response body: %w", err)
+			}
+			bodyBytes = detectionText(resp, bodyBytes)
+		}
+		return s.handleChallenge(ctx, req, resp, bodyBytes, name, h)
+	}
+	if req.Method == http.MethodHead && detection.Challenge {
+		if resp, ok, err := s.solveForHead(ctx, req, resp); ok {
+			return resp, err
+		}
+	}
+	if resp.StatusCode >= 400 {
+		if len(detection.Signals) > 0 {
+			s.logger.Printf("Challenge detection for %s: %s\n", req.URL, detection)
+		}
+		if detection.Challenge {
+			// The signals say this is a challenge, but no handler recognises it.
+			return nil, &errors.ChallengeError{
+				Stage:      errors.StageDetect,
+				URL:        req.URL.String(),
+				StatusCode: resp.StatusCode,
+				RayID:      resp.Header.Get("Cf-Ray"),
+				Err:        fmt.Errorf("%w: %s", errors.ErrUnknownChallenge, detection),
+			}
+		}
 	}
 
 	if resp.StatusCode == http.StatusForbidden && s.opts.AutoRefreshOn403 {
-		return s.handle403(req)
+		return s.handle403(ctx, req, resp, agent, gen)
 	}
 
-	if resp.StatusCode >= 300 && resp.StatusCode <= 399 {
-		loc, err := resp.Location()
-		if err != nil {
-			return resp, nil
-		}
-		redirectReq, _ := http.NewRequest("GET", loc.String(), nil)
-		return s.do(redirectReq)
+	if isRedirect(resp) {
+		return s.followRedirect(req, resp)
 	}
 
 	return resp, nil
 }
 
-func (s *Scraper) handle403(req *http.Request) (*http.Response, error) {
-	s.mu.Lock()
-	if s.in403Retry {
-		s.mu.Unlock()
-		return nil, errors.ErrMaxRetriesExceeded
+type in403RetryKey struct{}
+
+// handle403 refreshes the session and re-sends req up to Max403Retries times.
+// agent and gen are the identity and session generation req was sent under:
+// each retry drops agent's headers for the fresh identity's, and goroutines
+// hitting 403 at the same time rotate the identity once rather than once each. A
+// fresh identity whose warm-up request is refused too is not worth re-sending
+// req under, so the next refresh follows straight away.
+func (s *Scraper) handle403(ctx context.Context, req *http.Request, resp *http.Response, agent *useragent.Agent, gen uint64) (*http.Response, error) {
+	if ctx.Value(in403RetryKey{}) != nil {
+		// Already inside a refresh for this request; let the outer loop decide.
+		return resp, nil
 	}
-	s.in403Retry = true
-	s.last403Time = time.Now()
-	s.mu.Unlock()
-
-	defer func() {
-		s.mu.Lock()
-		s.in403Retry = false
-		s.mu.Unlock()
-	}()
+	resp.Body.Close()
+	ctx = context.WithValue(ctx, in403RetryKey{}, true)
 
 	for i := 0; i < s.opts.Max403Retries; i++ {
 		s.logger.Printf("Received 403. Refreshing session (attempt %d/%d)...\n", i+1, s.opts.Max403Retries)
-		if err := s.refreshSession(req.URL); err != nil {
+		rotated, err := s.rotateSession(req.URL, gen)
+		if err != nil {
 			return nil, fmt.Errorf("failed to refresh session after 403: %w", err)
 		}
+		_, gen = s.identity()
+		if rotated {
+			status, err := s.warmUp(ctx, req.URL)
+			if err != nil {
+				return nil, fmt.Errorf("failed to refresh session after 403: %w", err)
+			}
+			if status == http.StatusForbidden {
+				continue
+			}
+		}
 
-		resp, err := s.do(req)
+		retry, err := cloneForIdentity(req, agent)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(retry.WithContext(ctx))
 		if err == nil && resp.StatusCode != http.StatusForbidden {
 			return resp, nil
 		}
+		if err == nil {
+			resp.Body.Close()
+		}
 	}
 
-	return nil, errors.ErrMaxRetriesExceeded
+	return nil, &errors.RetryError{Attempts: s.opts.Max403Retries, StatusCode: http.StatusForbidden}
+}
+
+// identity returns the current browser profile and the session generation it belongs to.
+func (s *Scraper) identity() (*useragent.Agent, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.UserAgent, s.sessionGen
+}
+
+// maybeRefreshSession refreshes the session if AutoRefreshSession is due.
+func (s *Scraper) maybeRefreshSession(ctx context.Context, currentURL *url.URL) {
+	s.mu.Lock()
+	due := s.shouldRefreshSession()
+	gen := s.sessionGen
+	s.mu.Unlock()
+
+	if due {
+		if err := s.refreshSession(ctx, currentURL, gen); err != nil {
+			s.logger.Printf("Warning: session refresh failed: %v\n", err)
+		}
+	}
 }
 
 func (s *Scraper) shouldRefreshSession() bool {
@@ -268,28 +528,86 @@ func (s *Scraper) shouldRefreshSession() bool {
 	return time.Since(s.sessionStartTime) > s.opts.SessionRefreshInterval
 }
 
-func (s *Scraper) refreshSession(currentURL *url.URL) error {
-	s.logger.Println("Refreshing session...")
-	s.sessionStartTime = time.Now()
-	atomic.StoreInt32(&s.requestCount, 0)
+// refreshSession rotates the browser identity and warms up a session on
+// currentURL's host. If another goroutine already rotated past gen, the
+// rotation is skipped. s.mu is only held while swapping state, never across
+// the warm-up request.
+func (s *Scraper) refreshSession(ctx context.Context, currentURL *url.URL, gen uint64) error {
+	rotated, err := s.rotateSession(currentURL, gen)
+	if err != nil || !rotated {
+		return err
+	}
+	_, err = s.warmUp(ctx, currentURL)
+	return err
+}
 
+// rotateSession swaps in a fresh browser identity, unless another goroutine
+// already rotated past gen. It reports whether it rotated.
+func (s *Scraper) rotateSession(currentURL *url.URL, gen uint64) (bool, error) {
 	agent, err := useragent.New(s.opts.Browser)
 	if err != nil {
-		return err
+		return false, err
 	}
+
+	s.mu.Lock()
+	if s.sessionGen != gen {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.logger.Println("Refreshing session...")
+	s.sessionGen++
+	s.sessionStartTime = time.Now()
 	s.UserAgent = agent
+	s.mu.Unlock()
+	atomic.StoreInt32(&s.requestCount, 0)
 
 	if s.opts.RotateTlsCiphers {
 		if tr, ok := s.client.Transport.(*transport.CipherSuiteTransport); ok {
-			tr.SetCipherSuites(s.UserAgent.CipherSuites)
+			tr.SetCipherSuites(agent.CipherSuites)
 		}
 	}
 
 	if s.client.Jar != nil {
 		s.client.Jar.SetCookies(currentURL, []*http.Cookie{})
 	}
+	return true, nil
+}
 
+// warmUp requests the root of currentURL's host under the current identity,
+// solving any challenge it meets, and returns the response's status.
+func (s *Scraper) warmUp(ctx context.Context, currentURL *url.URL) (int, error) {
 	rootURL := &url.URL{Scheme: currentURL.Scheme, Host: currentURL.Host}
-	_, err = s.Get(rootURL.String())
-	return err
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, in403RetryKey{}, true), "GET", rootURL.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// reportProxyFailure bans proxyURL in the proxy manager and drops the idle
+// connections opened through it.
+func (s *Scraper) reportProxyFailure(proxyURL *url.URL) {
+	if s.ProxyManager != nil {
+		s.ProxyManager.ReportFailure(proxyURL)
+	}
+	if tr, ok := s.client.Transport.(*transport.CipherSuiteTransport); ok {
+		tr.CloseProxyConnections(proxyURL)
+	}
+}
+
+// sleepContext pauses for d, returning early with ctx's error if it is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,178 @@
+package cloudscraper
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/cftest"
+	"github.com/Advik-B/cloudscraper/lib/proxy"
+	"github.com/Advik-B/cloudscraper/lib/stealth"
+	"github.com/Advik-B/cloudscraper/lib/transport"
+)
+
+// runWorkers runs fn on n goroutines at once and reports the errors they
+// return.
+func runWorkers(t *testing.T, n int, fn func(i int) error) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := fn(i); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// getOK fetches url and checks for a 200, returning the body.
+func getOK(s *Scraper, url string) (string, error) {
+	resp, err := s.Get(url)
+	if err != nil {
+		return "", err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+	return string(body), nil
+}
+
+// TestConcurrentChallenge sends many requests at once into a challenge.
+// They must share a single solve and all reach the origin. Run it with
+// -race.
+func TestConcurrentChallenge(t *testing.T) {
+	for _, kind := range []cftest.Kind{cftest.Managed, cftest.V2} {
+		t.Run(string(kind), func(t *testing.T) {
+			srv := cftest.NewServer(cftest.Config{Challenge: kind})
+			defer srv.Close()
+			s := newTestScraper(t)
+
+			const workers = 30
+			runWorkers(t, workers, func(i int) error {
+				_, err := getOK(s, fmt.Sprintf("%s/item/%d", srv.URL, i))
+				return err
+			})
+
+			stats := srv.Stats()
+			if stats.Solved != 1 || stats.Failed != 0 {
+				t.Errorf("solved %d and failed %d challenges, want 1 and 0", stats.Solved, stats.Failed)
+			}
+			if stats.Passed != workers {
+				t.Errorf("origin saw %d requests, want %d", stats.Passed, workers)
+			}
+		})
+	}
+}
+
+// TestConcurrentForbidden sends many requests at once under an identity the
+// server refuses. The 403s they all get must rotate the identity once, and
+// every request must be re-sent under the new one.
+func TestConcurrentForbidden(t *testing.T) {
+	s := newTestScraper(t)
+	banned := s.UserAgent.Headers.Get("User-Agent")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") == banned {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	runWorkers(t, 30, func(i int) error {
+		_, err := getOK(s, fmt.Sprintf("%s/item/%d", srv.URL, i))
+		return err
+	})
+	if _, gen := s.identity(); gen != 1 {
+		t.Errorf("session rotated %d times, want 1", gen)
+	}
+}
+
+// TestConcurrentProxyPinning sends many requests at once through a pool of
+// proxies, with stealth headers on, while the cipher suites are rotated.
+// Each request and the redirect it follows must leave through the same
+// proxy, and every request must be counted once.
+func TestConcurrentProxyPinning(t *testing.T) {
+	const proxies, workers = 3, 30
+
+	// seen records, by proxy, the paths of the requests it forwarded.
+	var mu sync.Mutex
+	seen := map[string]map[string]int{}
+	var urls []string
+	for p := 0; p < proxies; p++ {
+		name := fmt.Sprintf("proxy%d", p)
+		seen[name] = map[string]int{}
+		// Requests for plain http origins reach a forward proxy with the
+		// origin's URL; this one answers them itself.
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			seen[name][r.URL.RequestURI()]++
+			mu.Unlock()
+			if r.URL.Path == "/start" {
+				http.Redirect(w, r, "/end?"+r.URL.RawQuery, http.StatusFound)
+				return
+			}
+			fmt.Fprint(w, name)
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+	s := newTestScraper(t,
+		WithProxies(urls, proxy.Random, time.Minute),
+		WithStealth(stealth.Options{Enabled: true, RandomizeHeaders: true, BrowserQuirks: true}),
+	)
+
+	tr := s.client.Transport.(*transport.CipherSuiteTransport)
+	stop := make(chan struct{})
+	rotated := make(chan struct{})
+	go func() {
+		defer close(rotated)
+		suites := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				tr.SetCipherSuites(suites[i%2:])
+			}
+		}
+	}()
+
+	runWorkers(t, workers, func(i int) error {
+		body, err := getOK(s, fmt.Sprintf("http://origin.test/start?id=%d", i))
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[body][fmt.Sprintf("/start?id=%d", i)] != 1 {
+			return fmt.Errorf("request %d was redirected from another proxy than %s", i, body)
+		}
+		return nil
+	})
+	close(stop)
+	<-rotated
+
+	if got := atomic.LoadInt32(&s.requestCount); got != 2*workers {
+		t.Errorf("scraper counted %d requests, want %d", got, 2*workers)
+	}
+}
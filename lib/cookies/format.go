@@ -0,0 +1,314 @@
+package cookies
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is an on-disk cookie file format.
+type Format int
+
+const (
+	// JSON is a JSON array of cookie objects. It preserves every attribute.
+	JSON Format = iota
+	// Netscape is the tab-separated cookies.txt format read by curl, wget and
+	// browser extensions. It has no column for SameSite, which is written
+	// on a "#SameSite=" comment line before the cookie; other tools skip it
+	// as a comment.
+	Netscape
+)
+
+// FormatForPath picks the format for a file name: Netscape for ".txt"
+// files, JSON otherwise.
+func FormatForPath(path string) Format {
+	if strings.EqualFold(filepath.Ext(path), ".txt") {
+		return Netscape
+	}
+	return JSON
+}
+
+// jsonEntry is the JSON representation of an Entry.
+type jsonEntry struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Domain   string     `json:"domain"`
+	Path     string     `json:"path"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	Secure   bool       `json:"secure"`
+	HttpOnly bool       `json:"httpOnly"`
+	HostOnly bool       `json:"hostOnly"`
+	SameSite string     `json:"sameSite,omitempty"`
+	Created  *time.Time `json:"created,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	je := jsonEntry{
+		Name:     e.Name,
+		Value:    e.Value,
+		Domain:   e.Domain,
+		Path:     e.Path,
+		Secure:   e.Secure,
+		HttpOnly: e.HttpOnly,
+		HostOnly: e.HostOnly,
+		SameSite: sameSiteString(e.SameSite),
+	}
+	if !e.Expires.IsZero() {
+		expires := e.Expires.UTC()
+		je.Expires = &expires
+	}
+	if !e.Created.IsZero() {
+		created := e.Created.UTC()
+		je.Created = &created
+	}
+	return json.Marshal(je)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	var je jsonEntry
+	if err := json.Unmarshal(data, &je); err != nil {
+		return err
+	}
+	sameSite, err := parseSameSite(je.SameSite)
+	if err != nil {
+		return err
+	}
+	*e = Entry{
+		Name:     je.Name,
+		Value:    je.Value,
+		Domain:   je.Domain,
+		Path:     je.Path,
+		Secure:   je.Secure,
+		HttpOnly: je.HttpOnly,
+		HostOnly: je.HostOnly,
+		SameSite: sameSite,
+	}
+	if je.Expires != nil {
+		e.Expires = *je.Expires
+	}
+	if je.Created != nil {
+		e.Created = *je.Created
+	}
+	return nil
+}
+
+// WriteJSON writes the jar's cookies to w as JSON.
+func (j *Jar) WriteJSON(w io.Writer) error {
+	entries := j.Entries()
+	if entries == nil {
+		entries = []Entry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// ReadJSON adds the cookies in the JSON document r to the jar.
+func (j *Jar) ReadJSON(r io.Reader) error {
+	var entries []Entry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return fmt.Errorf("cookies: invalid JSON cookie file: %w", err)
+	}
+	j.Import(entries)
+	return nil
+}
+
+const netscapeHeader = "# Netscape HTTP Cookie File\n# Generated by cloudscraper. Edit at your own risk.\n\n"
+
+const httpOnlyPrefix = "#HttpOnly_"
+
+// sameSitePrefix starts the comment line carrying the SameSite attribute of
+// the cookie on the next line.
+const sameSitePrefix = "#SameSite="
+
+// WriteNetscape writes the jar's cookies to w in the Netscape cookies.txt
+// format. Session cookies are written with an expiry of 0.
+func (j *Jar) WriteNetscape(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(netscapeHeader)
+	for _, e := range j.Entries() {
+		domain := e.Domain
+		if !e.HostOnly {
+			domain = "." + domain
+		}
+		if e.HttpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expires int64
+		if !e.Expires.IsZero() {
+			expires = e.Expires.Unix()
+		}
+		if sameSite := sameSiteString(e.SameSite); sameSite != "" {
+			bw.WriteString(sameSitePrefix + sameSite + "\n")
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(!e.HostOnly), e.Path, netscapeBool(e.Secure), expires, e.Name, e.Value)
+	}
+	return bw.Flush()
+}
+
+// ReadNetscape adds the cookies in the cookies.txt file r to the jar.
+func (j *Jar) ReadNetscape(r io.Reader) error {
+	var entries []Entry
+	var sameSite http.SameSite // for the next cookie line
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := false
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			httpOnly = true
+			line = line[len(httpOnlyPrefix):]
+		} else if value, ok := strings.CutPrefix(line, sameSitePrefix); ok {
+			var err error
+			if sameSite, err = parseSameSite(value); err != nil {
+				return fmt.Errorf("cookies: line %d: invalid SameSite value %q", lineNo, value)
+			}
+			continue
+		} else if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			fields = append(fields, "") // empty value with the trailing tab trimmed
+		}
+		if len(fields) != 7 {
+			return fmt.Errorf("cookies: line %d: expected 7 tab-separated fields, got %d", lineNo, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("cookies: line %d: invalid expiry %q", lineNo, fields[4])
+		}
+
+		e := Entry{
+			Domain:   fields[0],
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+			SameSite: sameSite,
+		}
+		sameSite = 0
+		if expires > 0 {
+			e.Expires = time.Unix(expires, 0)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	j.Import(entries)
+	return nil
+}
+
+// Write writes the jar's cookies to w in the given format.
+func (j *Jar) Write(w io.Writer, format Format) error {
+	if format == Netscape {
+		return j.WriteNetscape(w)
+	}
+	return j.WriteJSON(w)
+}
+
+// Read adds the cookies in r to the jar. The format is detected from the
+// content: a document starting with '[' is JSON, anything else cookies.txt.
+func (j *Jar) Read(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n', 0xEF, 0xBB, 0xBF: // whitespace and a UTF-8 BOM
+			br.ReadByte()
+			continue
+		case '[':
+			return j.ReadJSON(br)
+		}
+		return j.ReadNetscape(br)
+	}
+}
+
+// SaveFile writes the jar's cookies to path, in the format chosen by
+// FormatForPath. The file is replaced atomically and is readable only by
+// its owner, since it holds session credentials.
+func (j *Jar) SaveFile(path string) error {
+	j.fileMu.Lock()
+	defer j.fileMu.Unlock()
+
+	var buf bytes.Buffer
+	if err := j.Write(&buf, FormatForPath(path)); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadFile adds the cookies stored in path to the jar.
+func (j *Jar) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return j.Read(f)
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+func sameSiteString(s http.SameSite) string {
+	switch s {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	}
+	return ""
+}
+
+func parseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("cookies: invalid SameSite value %q", s)
+}
@@ -0,0 +1,201 @@
+package cookies
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var site = &url.URL{Scheme: "https", Host: "www.example.com", Path: "/app/page"}
+
+// testJar returns a jar holding a host-only persistent cookie, a domain
+// session cookie and a cookie with a path, with every SameSite mode.
+func testJar(t *testing.T) *Jar {
+	t.Helper()
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	j := New(nil)
+	j.SetCookies(site, []*http.Cookie{
+		{Name: "host", Value: "1", Expires: expires, Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode},
+		{Name: "cf_clearance", Value: "abc", Domain: "example.com", Path: "/", Secure: true, SameSite: http.SameSiteNoneMode},
+		{Name: "pref", Value: "dark", Path: "/app", SameSite: http.SameSiteStrictMode},
+		{Name: "plain", Value: "x", Path: "/"},
+	})
+	if n := len(j.Entries()); n != 4 {
+		t.Fatalf("jar holds %d cookies, want 4", n)
+	}
+	return j
+}
+
+// sameEntries compares the saved attributes of two entry lists; creation
+// times are only kept by JSON and are compared there.
+func sameEntries(t *testing.T, got, want []Entry, created bool) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d cookies, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Name != w.Name || g.Value != w.Value || g.Domain != w.Domain || g.Path != w.Path ||
+			!g.Expires.Equal(w.Expires) || g.Secure != w.Secure || g.HttpOnly != w.HttpOnly ||
+			g.HostOnly != w.HostOnly || g.SameSite != w.SameSite || (created && !g.Created.Equal(w.Created)) {
+			t.Errorf("cookie %d:\n got %+v\nwant %+v", i, g, w)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, format := range map[string]Format{"json": JSON, "netscape": Netscape} {
+		t.Run(name, func(t *testing.T) {
+			j := testJar(t)
+			var buf bytes.Buffer
+			if err := j.Write(&buf, format); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			restored := New(nil)
+			if err := restored.Read(&buf); err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			sameEntries(t, restored.Entries(), j.Entries(), format == JSON)
+
+			// The restored jar sends what the original would.
+			for _, u := range []string{"https://www.example.com/app/x", "https://sub.example.com/", "http://www.example.com/"} {
+				target, _ := url.Parse(u)
+				if got, want := fmt.Sprint(restored.Cookies(target)), fmt.Sprint(j.Cookies(target)); got != want {
+					t.Errorf("cookies for %s: got %s, want %s", u, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadSkipsExpired(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC()
+	future := time.Now().Add(time.Hour).UTC()
+	files := map[string]string{
+		"json": fmt.Sprintf(`[
+  {"name": "old", "value": "1", "domain": "example.com", "path": "/", "expires": %q, "hostOnly": true},
+  {"name": "new", "value": "2", "domain": "example.com", "path": "/", "expires": %q, "hostOnly": true}
+]`, past.Format(time.RFC3339), future.Format(time.RFC3339)),
+		"netscape": fmt.Sprintf("# Netscape HTTP Cookie File\n"+
+			"example.com\tFALSE\t/\tFALSE\t%d\told\t1\n"+
+			"example.com\tFALSE\t/\tFALSE\t%d\tnew\t2\n", past.Unix(), future.Unix()),
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			j := New(nil)
+			if err := j.Read(strings.NewReader(content)); err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			entries := j.Entries()
+			if len(entries) != 1 || entries[0].Name != "new" {
+				t.Errorf("loaded %+v, want only the unexpired cookie", entries)
+			}
+		})
+	}
+}
+
+func TestNetscapeSameSite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testJar(t).WriteNetscape(&buf); err != nil {
+		t.Fatalf("WriteNetscape: %v", err)
+	}
+	// Tools that know nothing of SameSite see comments and seven columns.
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" || (strings.HasPrefix(line, "#") && !strings.HasPrefix(line, httpOnlyPrefix)) {
+			continue
+		}
+		if n := len(strings.Split(line, "\t")); n != 7 {
+			t.Errorf("line %q has %d columns, want 7", line, n)
+		}
+	}
+	if !strings.Contains(buf.String(), "#SameSite=None\n.example.com\t") {
+		t.Errorf("SameSite of the domain cookie not written before it:\n%s", buf.String())
+	}
+
+	j := New(nil)
+	err := j.ReadNetscape(strings.NewReader("#SameSite=Sometimes\nexample.com\tFALSE\t/\tFALSE\t0\ta\tb\n"))
+	if err == nil {
+		t.Error("invalid SameSite value accepted")
+	}
+}
+
+func TestAutosave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	j := New(nil)
+	saves := 0
+	j.OnChange(func() {
+		saves++
+		if err := j.SaveFile(path); err != nil {
+			t.Errorf("SaveFile: %v", err)
+		}
+	})
+
+	cookie := &http.Cookie{Name: "cf_clearance", Value: "abc", Domain: "example.com", SameSite: http.SameSiteNoneMode, Secure: true}
+	j.SetCookies(site, []*http.Cookie{cookie})
+	j.SetCookies(site, []*http.Cookie{cookie}) // unchanged
+	if saves != 1 {
+		t.Errorf("jar saved %d times, want 1", saves)
+	}
+	restored := New(nil)
+	if err := restored.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	sameEntries(t, restored.Entries(), j.Entries(), true)
+
+	j.SetCookies(site, []*http.Cookie{{Name: "cf_clearance", Domain: "example.com", MaxAge: -1}})
+	if saves != 2 {
+		t.Errorf("removal saved %d times in all, want 2", saves)
+	}
+	restored = New(nil)
+	if err := restored.LoadFile(path); err != nil || len(restored.Entries()) != 0 {
+		t.Errorf("after removal the file holds %v (%v), want no cookies", restored.Entries(), err)
+	}
+}
+
+func TestSaveFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cookies.json")
+	if err := os.WriteFile(path, []byte("old content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	j := testJar(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			j.SetCookies(site, []*http.Cookie{{Name: fmt.Sprintf("c%d", i), Value: "v"}})
+			if err := j.SaveFile(path); err != nil {
+				t.Errorf("SaveFile: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("saved file is not a whole JSON document: %v", err)
+	}
+	if len(entries) != 14 {
+		t.Errorf("saved file holds %d cookies, want 14", len(entries))
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("saved file mode %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files in the directory, want only the cookie file", len(files))
+	}
+}
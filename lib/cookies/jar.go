@@ -0,0 +1,481 @@
+package cookies
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errIllegalDomain   = errors.New("cookies: illegal cookie domain attribute")
+	errMalformedDomain = errors.New("cookies: malformed cookie domain attribute")
+)
+
+// Entry is a cookie as stored in the jar, with every attribute needed to
+// restore it later.
+type Entry struct {
+	Name     string
+	Value    string
+	Domain   string // without a leading dot
+	Path     string
+	Expires  time.Time // zero for session cookies
+	Secure   bool
+	HttpOnly bool
+	HostOnly bool // sent only to Domain itself, not its subdomains
+	SameSite http.SameSite
+	Created  time.Time
+
+	seq uint64 // insertion order, to break ties between equal creation times
+}
+
+// Expired reports whether the entry has expired at now.
+func (e *Entry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !e.Expires.After(now)
+}
+
+// Cookie converts the entry to an *http.Cookie suitable for inspection.
+func (e *Entry) Cookie() *http.Cookie {
+	c := &http.Cookie{
+		Name:     e.Name,
+		Value:    e.Value,
+		Path:     e.Path,
+		Expires:  e.Expires,
+		Secure:   e.Secure,
+		HttpOnly: e.HttpOnly,
+		SameSite: e.SameSite,
+	}
+	if !e.HostOnly {
+		c.Domain = e.Domain
+	}
+	return c
+}
+
+func (e *Entry) id() string {
+	return e.Name + ";" + e.Domain + ";" + e.Path
+}
+
+// Jar is an RFC 6265 cookie jar that, unlike net/http/cookiejar, can list
+// its contents and be saved to and restored from disk. It is safe for
+// concurrent use.
+type Jar struct {
+	psl cookiejar.PublicSuffixList
+
+	mu       sync.Mutex
+	entries  map[string]map[string]Entry // keyed by eTLD+1, then Entry.id
+	nextSeq  uint64
+	onChange func()
+
+	fileMu sync.Mutex // serialises SaveFile calls
+}
+
+// New creates an empty jar. psl may be nil, in which case cookies can be set
+// on public suffixes such as "co.uk"; publicsuffix.List is the usual choice.
+func New(psl cookiejar.PublicSuffixList) *Jar {
+	return &Jar{psl: psl, entries: make(map[string]map[string]Entry)}
+}
+
+// OnChange registers fn to be called after every change to the jar's
+// contents. fn is called without the jar's lock held, so it may read the jar.
+func (j *Jar) OnChange(fn func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.onChange = fn
+}
+
+// SetCookies implements http.CookieJar.
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if len(cookies) == 0 || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return
+	}
+	key := j.jarKey(host)
+	defPath := defaultPath(u.Path)
+	now := time.Now()
+
+	j.mu.Lock()
+	changed := false
+	submap := j.entries[key]
+	for _, c := range cookies {
+		e, remove, err := j.newEntry(c, now, defPath, host)
+		if err != nil {
+			continue
+		}
+		id := e.id()
+		old, exists := submap[id]
+		if remove {
+			if exists {
+				delete(submap, id)
+				changed = true
+			}
+			continue
+		}
+		if submap == nil {
+			submap = make(map[string]Entry)
+			j.entries[key] = submap
+		}
+		if exists {
+			e.Created, e.seq = old.Created, old.seq
+			if sameContent(old, e) {
+				continue
+			}
+		} else {
+			e.Created = now
+			e.seq = j.nextSeq
+			j.nextSeq++
+		}
+		submap[id] = e
+		changed = true
+	}
+	if len(submap) == 0 {
+		delete(j.entries, key)
+	}
+	onChange := j.onChange
+	j.mu.Unlock()
+
+	if changed && onChange != nil {
+		onChange()
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return nil
+	}
+	key := j.jarKey(host)
+	https := u.Scheme == "https"
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	now := time.Now()
+
+	j.mu.Lock()
+	var selected []Entry
+	submap := j.entries[key]
+	for id, e := range submap {
+		if e.Expired(now) {
+			delete(submap, id)
+			continue
+		}
+		if e.shouldSend(https, host, path) {
+			selected = append(selected, e)
+		}
+	}
+	if len(submap) == 0 {
+		delete(j.entries, key)
+	}
+	j.mu.Unlock()
+
+	// RFC 6265 §5.4: longer paths first, then earlier creation.
+	sort.Slice(selected, func(i, k int) bool {
+		a, b := selected[i], selected[k]
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) > len(b.Path)
+		}
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created)
+		}
+		return a.seq < b.seq
+	})
+
+	cookies := make([]*http.Cookie, len(selected))
+	for i, e := range selected {
+		cookies[i] = &http.Cookie{Name: e.Name, Value: e.Value}
+	}
+	return cookies
+}
+
+// Lookup returns the cookie called name that the jar would send to u.
+func (j *Jar) Lookup(u *url.URL, name string) (Entry, bool) {
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return Entry{}, false
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	https := u.Scheme == "https"
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var best Entry
+	found := false
+	for _, e := range j.entries[j.jarKey(host)] {
+		if e.Name != name || e.Expired(now) || !e.shouldSend(https, host, path) {
+			continue
+		}
+		// The most specific path wins, as it is sent first.
+		if !found || len(e.Path) > len(best.Path) {
+			best, found = e, true
+		}
+	}
+	return best, found
+}
+
+// Entries returns every unexpired cookie in the jar, sorted by domain, path
+// and name.
+func (j *Jar) Entries() []Entry {
+	now := time.Now()
+	j.mu.Lock()
+	var all []Entry
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if !e.Expired(now) {
+				all = append(all, e)
+			}
+		}
+	}
+	j.mu.Unlock()
+
+	sort.Slice(all, func(i, k int) bool {
+		a, b := all[i], all[k]
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Name < b.Name
+	})
+	return all
+}
+
+// Import adds entries to the jar as they are, replacing cookies with the
+// same name, domain and path. Expired entries are skipped.
+func (j *Jar) Import(entries []Entry) {
+	now := time.Now()
+
+	j.mu.Lock()
+	changed := false
+	for _, e := range entries {
+		if e.Expired(now) || e.Name == "" || e.Domain == "" {
+			continue
+		}
+		e.Domain = strings.ToLower(strings.TrimPrefix(e.Domain, "."))
+		if e.Path == "" {
+			e.Path = "/"
+		}
+		if e.Created.IsZero() {
+			e.Created = now
+		}
+		e.seq = j.nextSeq
+		j.nextSeq++
+
+		key := j.jarKey(e.Domain)
+		if j.entries[key] == nil {
+			j.entries[key] = make(map[string]Entry)
+		}
+		j.entries[key][e.id()] = e
+		changed = true
+	}
+	onChange := j.onChange
+	j.mu.Unlock()
+
+	if changed && onChange != nil {
+		onChange()
+	}
+}
+
+// Remove deletes the cookies with the same name, domain and path as
+// entries, whatever their values.
+func (j *Jar) Remove(entries []Entry) {
+	j.mu.Lock()
+	changed := false
+	for _, e := range entries {
+		e.Domain = strings.ToLower(strings.TrimPrefix(e.Domain, "."))
+		if e.Path == "" {
+			e.Path = "/"
+		}
+		key := j.jarKey(e.Domain)
+		if _, ok := j.entries[key][e.id()]; ok {
+			delete(j.entries[key], e.id())
+			if len(j.entries[key]) == 0 {
+				delete(j.entries, key)
+			}
+			changed = true
+		}
+	}
+	onChange := j.onChange
+	j.mu.Unlock()
+
+	if changed && onChange != nil {
+		onChange()
+	}
+}
+
+func (e *Entry) shouldSend(https bool, host, path string) bool {
+	return e.domainMatch(host) && e.pathMatch(path) && (https || !e.Secure)
+}
+
+func (e *Entry) domainMatch(host string) bool {
+	if e.Domain == host {
+		return true
+	}
+	return !e.HostOnly && hasDotSuffix(host, e.Domain)
+}
+
+func (e *Entry) pathMatch(requestPath string) bool {
+	if requestPath == e.Path {
+		return true
+	}
+	if strings.HasPrefix(requestPath, e.Path) {
+		if e.Path[len(e.Path)-1] == '/' {
+			return true // The "/any/" matches "/any/path" case.
+		} else if requestPath[len(e.Path)] == '/' {
+			return true // The "/any" matches "/any/path" case.
+		}
+	}
+	return false
+}
+
+// newEntry creates an entry from c, received from host. remove reports
+// whether c deletes any existing cookie with the same id instead.
+func (j *Jar) newEntry(c *http.Cookie, now time.Time, defPath, host string) (e Entry, remove bool, err error) {
+	e.Name = c.Name
+	if c.Path == "" || c.Path[0] != '/' {
+		e.Path = defPath
+	} else {
+		e.Path = c.Path
+	}
+
+	e.Domain, e.HostOnly, err = j.domainAndType(host, c.Domain)
+	if err != nil {
+		return e, false, err
+	}
+
+	// MaxAge takes precedence over Expires.
+	if c.MaxAge < 0 {
+		return e, true, nil
+	} else if c.MaxAge > 0 {
+		e.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+	} else if !c.Expires.IsZero() {
+		if !c.Expires.After(now) {
+			return e, true, nil
+		}
+		e.Expires = c.Expires
+	}
+
+	e.Value = c.Value
+	e.Secure = c.Secure
+	e.HttpOnly = c.HttpOnly
+	e.SameSite = c.SameSite
+	return e, false, nil
+}
+
+// domainAndType determines the cookie's domain and whether it is host-only.
+func (j *Jar) domainAndType(host, domain string) (string, bool, error) {
+	if domain == "" {
+		// No domain attribute: a host-only cookie.
+		return host, true, nil
+	}
+
+	if isIP(host) {
+		if domain == host {
+			return host, true, nil
+		}
+		return "", false, errIllegalDomain
+	}
+
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if len(domain) == 0 || domain[0] == '.' || domain[len(domain)-1] == '.' {
+		return "", false, errMalformedDomain
+	}
+
+	if j.psl != nil {
+		if ps := j.psl.PublicSuffix(domain); ps != "" && !hasDotSuffix(domain, ps) {
+			if host == domain {
+				// A public suffix may only set a host-only cookie on itself.
+				return host, true, nil
+			}
+			return "", false, errIllegalDomain
+		}
+	}
+
+	if host != domain && !hasDotSuffix(host, domain) {
+		return "", false, errIllegalDomain
+	}
+	return domain, false, nil
+}
+
+// jarKey returns the eTLD+1 of host, which groups cookies that may be shared.
+// Without a public suffix list, the last label is taken as the suffix, as
+// net/http/cookiejar does.
+func (j *Jar) jarKey(host string) string {
+	if isIP(host) {
+		return host
+	}
+	var i int // start of the public suffix
+	if j.psl == nil {
+		i = strings.LastIndex(host, ".") + 1
+	} else {
+		suffix := j.psl.PublicSuffix(host)
+		if suffix == host {
+			return host
+		}
+		i = len(host) - len(suffix)
+	}
+	if i <= 1 || host[i-1] != '.' {
+		return host
+	}
+	prevDot := strings.LastIndex(host[:i-1], ".")
+	return host[prevDot+1:]
+}
+
+func sameContent(a, b Entry) bool {
+	return a.Value == b.Value && a.Expires.Equal(b.Expires) && a.Secure == b.Secure &&
+		a.HttpOnly == b.HttpOnly && a.HostOnly == b.HostOnly && a.SameSite == b.SameSite
+}
+
+func canonicalHost(host string) (string, error) {
+	if strings.Contains(host, ":") {
+		h, _, err := net.SplitHostPort(host)
+		if err != nil && !isIP(strings.Trim(host, "[]")) {
+			return "", err
+		}
+		if err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return "", errMalformedDomain
+	}
+	return host, nil
+}
+
+func defaultPath(path string) string {
+	if len(path) == 0 || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+func hasDotSuffix(s, suffix string) bool {
+	return len(s) > len(suffix) && s[len(s)-len(suffix)-1] == '.' && s[len(s)-len(suffix):] == suffix
+}
+
+func isIP(host string) bool {
+	return net.ParseIP(host) != nil
+}
@@ -0,0 +1,49 @@
+package cookies
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+func TestJarMatching(t *testing.T) {
+	j := New(publicsuffix.List)
+	j.SetCookies(site, []*http.Cookie{
+		{Name: "host", Value: "1", Path: "/"},
+		{Name: "domain", Value: "2", Domain: ".Example.com", Path: "/"},
+		{Name: "secure", Value: "3", Domain: "example.com", Path: "/", Secure: true},
+		{Name: "path", Value: "4", Path: "/app/"},
+		{Name: "suffix", Value: "5", Domain: "com"},                     // public suffix: refused
+		{Name: "other", Value: "6", Domain: "other.com"},                // foreign domain: refused
+		{Name: "gone", Value: "7", Expires: time.Now().Add(-time.Hour)}, // already expired: not stored
+	})
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.example.com/app/x", "[path=4 host=1 domain=2 secure=3]"},
+		{"http://www.example.com/", "[host=1 domain=2]"},
+		{"https://sub.www.example.com/", "[domain=2 secure=3]"},
+		{"https://example.com/app", "[domain=2 secure=3]"},
+		{"https://other.com/", "[]"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := fmt.Sprint(j.Cookies(u)); got != tt.want {
+			t.Errorf("Cookies(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+
+	// Max-Age < 0 deletes, whatever the value.
+	j.SetCookies(site, []*http.Cookie{{Name: "domain", Domain: "example.com", Path: "/", MaxAge: -1}})
+	if _, ok := j.Lookup(site, "domain"); ok {
+		t.Error("cookie deleted with Max-Age -1 is still there")
+	}
+	if e, ok := j.Lookup(site, "host"); !ok || !e.HostOnly || e.Domain != "www.example.com" {
+		t.Errorf("Lookup(host) = %+v, %v", e, ok)
+	}
+}
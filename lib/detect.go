@@ -0,0 +1,121 @@
+package cloudscraper
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Signal is one piece of evidence that a response is a Cloudflare challenge.
+type Signal string
+
+const (
+	SignalMitigated Signal = "cf-mitigated"       // cf-mitigated: challenge header
+	SignalServer    Signal = "server"             // Server: cloudflare
+	SignalRay       Signal = "cf-ray"             // cf-ray header
+	SignalStatus    Signal = "status"             // 403, 429 or 503
+	SignalTitle     Signal = "title"              // <title>Just a moment...</title>
+	SignalToken     Signal = "__cf_chl"           // __cf_chl_* tokens, e.g. __cf_chl_tk
+	SignalPlatform  Signal = "challenge-platform" // a /cdn-cgi/challenge-platform/ orchestrate script
+	SignalChlOpt    Signal = "_cf_chl_opt"        // the window._cf_chl_opt object
+	SignalJSChl     Signal = "jschl"              // the classic v1 form and trace image
+	SignalSiteKey   Signal = "sitekey"            // an embedded captcha widget
+)
+
+// signalWeights is how much each signal counts towards ChallengeThreshold.
+var signalWeights = map[Signal]int{
+	SignalMitigated: ChallengeThreshold,
+	SignalServer:    1,
+	SignalRay:       1,
+	SignalStatus:    1,
+	SignalTitle:     2,
+	SignalToken:     3,
+	SignalPlatform:  3,
+	SignalChlOpt:    3,
+	SignalJSChl:     3,
+	SignalSiteKey:   2,
+}
+
+// ChallengeThreshold is the score at which a response with a challenge
+// status is treated as a challenge.
+const ChallengeThreshold = 5
+
+var (
+	chlTokenRegex = regexp.MustCompile(`__cf_chl_\w+`)
+	chlOptRegex   = regexp.MustCompile(`_cf_chl_opt\s*=`)
+	jschlRegex    = regexp.MustCompile(`(?i)cdn-cgi/images/trace/jsch/|name="jschl_vc"`)
+	titleRegex    = regexp.MustCompile(`(?i)<title>\s*Just a moment\.\.\.\s*</title>`)
+	// Only the orchestrate scripts belong to challenges; the same directory
+	// also serves the bot-detection script injected into ordinary pages.
+	platformRegex = regexp.MustCompile(`/cdn-cgi/challenge-platform/[^"'\s]*orchestrate/`)
+)
+
+// Detection is the outcome of scoring a response for challenge signals.
+type Detection struct {
+	// Challenge reports whether the response is treated as a challenge.
+	Challenge bool
+	// Score is the sum of the weights of the signals that fired.
+	Score int
+	// Signals lists the signals that fired, in the order they were checked.
+	Signals []Signal
+}
+
+func (d Detection) String() string {
+	verdict := "not a challenge"
+	if d.Challenge {
+		verdict = "challenge"
+	}
+	names := make([]string, len(d.Signals))
+	for i, s := range d.Signals {
+		names[i] = string(s)
+	}
+	return fmt.Sprintf("%s (score %d/%d: %s)", verdict, d.Score, ChallengeThreshold, strings.Join(names, ", "))
+}
+
+// Has reports whether sig fired.
+func (d Detection) Has(sig Signal) bool {
+	for _, s := range d.Signals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectChallenge scores resp, whose body has been read into body, for signs
+// of a Cloudflare challenge. No single marker is conclusive: pages behind
+// Cloudflare carry its headers whatever their status, and a customer's proxy
+// may rewrite the Server header. A response is therefore treated as a
+// challenge when it carries cf-mitigated: challenge, or when it has a
+// challenge status and its signals reach ChallengeThreshold. The body of a
+// response that has neither is not scanned, since it cannot be a challenge.
+func DetectChallenge(resp *http.Response, body []byte) Detection {
+	var d Detection
+	fire := func(sig Signal, ok bool) {
+		if ok {
+			d.Signals = append(d.Signals, sig)
+			d.Score += signalWeights[sig]
+		}
+	}
+
+	fire(SignalMitigated, strings.EqualFold(resp.Header.Get("Cf-Mitigated"), "challenge"))
+	fire(SignalServer, strings.HasPrefix(strings.ToLower(resp.Header.Get("Server")), "cloudflare"))
+	fire(SignalRay, resp.Header.Get("Cf-Ray") != "")
+	status := resp.StatusCode == http.StatusForbidden ||
+		resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusServiceUnavailable
+	fire(SignalStatus, status)
+	if !status && !d.Has(SignalMitigated) {
+		return d
+	}
+	fire(SignalTitle, titleRegex.Match(body))
+	fire(SignalToken, chlTokenRegex.Match(body))
+	fire(SignalPlatform, platformRegex.Match(body))
+	fire(SignalChlOpt, chlOptRegex.Match(body))
+	fire(SignalJSChl, jschlRegex.Match(body))
+	fire(SignalSiteKey, captchaDetectRegex.Match(body))
+
+	d.Challenge = d.Has(SignalMitigated) || (status && d.Score >= ChallengeThreshold)
+	return d
+}
@@ -0,0 +1,70 @@
+package cloudscraper
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDetectChallenge(t *testing.T) {
+	page := []byte(`<html><head><title>Just a moment...</title></head><body>
+<script src="/cdn-cgi/challenge-platform/h/b/orchestrate/chl_page/v1?ray=1"></script>
+<script>window._cf_chl_opt = {cType: 'managed'};</script>
+<form action="/?__cf_chl_f_tk=abc"></form></body></html>`)
+	tests := []struct {
+		name      string
+		status    int
+		mitigated string
+		challenge bool
+		scanned   bool
+	}{
+		{"challenge status", http.StatusServiceUnavailable, "", true, true},
+		{"429", http.StatusTooManyRequests, "", true, true},
+		{"cf-mitigated on 200", http.StatusOK, "challenge", true, true},
+		{"200 page quoting a challenge", http.StatusOK, "", false, false},
+		{"404 page quoting a challenge", http.StatusNotFound, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			resp.Header.Set("Server", "cloudflare")
+			resp.Header.Set("Cf-Ray", "8a1b2c3d4e5f6a7b-LHR")
+			if tt.mitigated != "" {
+				resp.Header.Set("Cf-Mitigated", tt.mitigated)
+			}
+
+			d := DetectChallenge(resp, page)
+			if d.Challenge != tt.challenge {
+				t.Errorf("Challenge = %v, want %v (%s)", d.Challenge, tt.challenge, d)
+			}
+			if d.Has(SignalTitle) != tt.scanned {
+				t.Errorf("body scanned = %v, want %v (%s)", d.Has(SignalTitle), tt.scanned, d)
+			}
+		})
+	}
+}
+
+func TestRegistryDetectsBuiltinKinds(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+	resp.Header.Set("Server", "cloudflare")
+	resp.Header.Set("Cf-Ray", "8a1b2c3d4e5f6a7b-LHR")
+	tests := []struct {
+		name string
+		body string
+	}{
+		{ChallengeOrchestrate, `<title>Just a moment...</title><script src="/cdn-cgi/challenge-platform/h/b/orchestrate/chl_page/v1?ray=1"></script>`},
+		{ChallengeV1, `<title>Just a moment...</title><form id="challenge-form"><input type="hidden" name="jschl_vc" value="1"/></form>`},
+		{ChallengeCaptcha, `<title>Just a moment...</title><div class="cf-turnstile" data-sitekey="0x4AAA"></div>`},
+	}
+	r := NewChallengeRegistry()
+	for _, tt := range tests {
+		name, h := r.Detect(resp, []byte(tt.body))
+		if h == nil || name != tt.name {
+			t.Errorf("%s page detected as %q", tt.name, name)
+		}
+	}
+
+	ok := &http.Response{StatusCode: http.StatusOK, Header: resp.Header}
+	if name, h := r.Detect(ok, []byte(tests[0].body)); h != nil {
+		t.Errorf("200 response detected as %q", name)
+	}
+}
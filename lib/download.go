@@ -0,0 +1,403 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/clearance"
+	"github.com/Advik-B/cloudscraper/lib/errors"
+	"github.com/Advik-B/cloudscraper/lib/transport"
+)
+
+// DownloadOptions configures Scraper.Download. The zero value is usable.
+type DownloadOptions struct {
+	// Referer is the URL of the page that linked to the file. It is sent as
+	// the Referer header and, when the file's host has no clearance of its
+	// own, the clearance held for the page's host decides the identity the
+	// download runs under.
+	Referer string
+	// Header holds extra headers to send with every request.
+	Header http.Header
+	// SHA256 is the hex-encoded digest the file must have. A file that does
+	// not match is removed and the download fails with
+	// errors.ErrChecksumMismatch.
+	SHA256 string
+	// Progress is called whenever data is written, with the bytes written
+	// so far and the file's size, or -1 if the server did not give it.
+	Progress func(written, total int64)
+	// MaxResumes bounds how often an interrupted transfer is resumed.
+	// Defaults to 5; a negative value disables resuming.
+	MaxResumes int
+}
+
+// Download streams the file at rawURL to dst and returns its size. The file
+// is written to dst+".part" and renamed into place once complete.
+//
+// A transfer that fails part way, say because ctx was cancelled, leaves
+// the .part file behind along with the validator to resume it against, in
+// dst+".part.validator". A later call for the same dst picks up from there.
+// Files that cannot be resumed are removed instead.
+//
+// Every request of the transfer runs under the identity of the clearance
+// held for the file's host, or for the host of opts.Referer: its
+// User-Agent, its proxy and, through the cookie jar, the cf_clearance
+// cookie itself. A transfer that breaks off is resumed with a Range request
+// guarded by If-Range, so a file that changed in the meantime is fetched
+// from the start rather than spliced, as is one that the server refuses to
+// resume with a 416. A resumed request that is challenged goes through the
+// challenge flow like any other.
+func (s *Scraper) Download(ctx context.Context, rawURL, dst string, opts *DownloadOptions) (int64, error) {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, err
+	}
+	var want []byte
+	if opts.SHA256 != "" {
+		if want, err = hex.DecodeString(opts.SHA256); err != nil || len(want) != sha256.Size {
+			return 0, fmt.Errorf("invalid SHA-256 digest %q", opts.SHA256)
+		}
+	}
+	maxResumes := opts.MaxResumes
+	if maxResumes == 0 {
+		maxResumes = 5
+	}
+
+	ctx, userAgent, err := s.downloadIdentity(withStreaming(ctx), target, opts.Referer)
+	if err != nil {
+		return 0, err
+	}
+
+	part := dst + ".part"
+	d := &download{s: s, url: target, opts: opts, userAgent: userAgent, hash: sha256.New(), total: -1}
+	if err := d.open(part); err != nil {
+		return 0, err
+	}
+	err = d.run(ctx, maxResumes)
+	if closeErr := d.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && want != nil {
+		if sum := d.hash.Sum(nil); !bytes.Equal(sum, want) {
+			err = fmt.Errorf("%w: %s has SHA-256 %x, want %x", errors.ErrChecksumMismatch, target, sum, want)
+		}
+	}
+	if err == nil {
+		err = os.Rename(part, dst)
+	}
+	if err != nil {
+		if d.validator == "" || stderrors.Is(err, errors.ErrChecksumMismatch) {
+			os.Remove(part)
+			os.Remove(part + validatorSuffix)
+		}
+		return d.written, err
+	}
+	os.Remove(part + validatorSuffix)
+	return d.written, nil
+}
+
+// downloadIdentity pins the proxy and User-Agent a download runs under to
+// those of the clearance held for target's host or, failing that, for the
+// referring page's host. Without a clearance it pins the current
+// User-Agent and a proxy picked once, so that resumed requests present the
+// same identity as the first.
+func (s *Scraper) downloadIdentity(ctx context.Context, target *url.URL, referer string) (context.Context, string, error) {
+	c := s.Clearance(target.Hostname())
+	if c == nil && referer != "" {
+		if ref, err := url.Parse(referer); err == nil {
+			c = s.Clearance(ref.Hostname())
+		}
+	}
+
+	if transport.ProxyFromContext(ctx) == nil {
+		var proxyURL *url.URL
+		switch {
+		case c != nil && c.Proxy != "":
+			proxyURL = s.proxyForEgress(c.Proxy)
+		case s.ProxyManager != nil:
+			var err error
+			if proxyURL, err = s.ProxyManager.GetProxy(); err != nil {
+				return nil, "", err
+			}
+		}
+		if proxyURL != nil {
+			ctx = transport.WithProxy(ctx, proxyURL)
+		}
+	}
+
+	if c != nil {
+		return ctx, c.UserAgent, nil
+	}
+	agent, _ := s.identity()
+	return ctx, agent.Headers.Get("User-Agent"), nil
+}
+
+// proxyForEgress returns the configured proxy, credentials included, whose
+// egress is the given clearance.Key.Proxy value.
+func (s *Scraper) proxyForEgress(egress string) *url.URL {
+	for _, p := range s.opts.Proxies {
+		if u, err := url.Parse(p); err == nil && clearance.ProxyEgress(u) == egress {
+			return u
+		}
+	}
+	return nil
+}
+
+// download is the state of a transfer in progress.
+type download struct {
+	s         *Scraper
+	url       *url.URL
+	opts      *DownloadOptions
+	userAgent string
+
+	file      *os.File
+	hash      hash.Hash
+	written   int64
+	total     int64  // -1 if unknown
+	validator string // the ETag or Last-Modified to resume against, if any
+	writeErr  error
+}
+
+// validatorSuffix names the file, next to the .part file, that holds the
+// validator a later call resumes against.
+const validatorSuffix = ".validator"
+
+// open opens the .part file at part. If an earlier call left one behind
+// with its validator, the transfer carries on from its end and its content
+// is fed to the digest; any other leftover is discarded. The file is opened
+// for appending, so writes always land at its end.
+func (d *download) open(part string) error {
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+	if err != nil {
+		return err
+	}
+	d.file = f
+
+	validator, err := os.ReadFile(part + validatorSuffix)
+	if err != nil || len(validator) == 0 {
+		if err := d.restart(); err != nil {
+			f.Close()
+			return err
+		}
+		return nil
+	}
+	r, err := os.Open(part)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer r.Close()
+	if d.written, err = io.Copy(d.hash, r); err != nil {
+		f.Close()
+		return err
+	}
+	d.validator = string(validator)
+	return nil
+}
+
+// setValidator records the validator to resume against, on disk too, so a
+// later call can resume the file.
+func (d *download) setValidator(validator string) error {
+	d.validator = validator
+	path := d.file.Name() + validatorSuffix
+	if validator == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(path, []byte(validator), 0o666)
+}
+
+// run transfers the file, resuming up to maxResumes times after the
+// connection breaks off.
+func (d *download) run(ctx context.Context, maxResumes int) error {
+	for resumes := 0; ; resumes++ {
+		resumable, err := d.attempt(ctx)
+		if err == nil {
+			return nil
+		}
+		if !resumable || resumes >= maxResumes || ctx.Err() != nil {
+			return err
+		}
+		d.s.logger.Printf("Download of %s interrupted after %d bytes (%v), resuming\n", d.url, d.written, err)
+		if err := sleepContext(ctx, time.Duration(resumes+1)*500*time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
+// attempt sends one request for the rest of the file and copies its body to
+// disk. resumable reports whether a failure happened mid-transfer.
+func (d *download) attempt(ctx context.Context) (resumable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	for name, values := range d.opts.Header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	if d.userAgent != "" {
+		req.Header.Set("User-Agent", d.userAgent)
+	}
+	if d.opts.Referer != "" {
+		req.Header.Set("Referer", d.opts.Referer)
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		// Byte ranges only line up with the file as stored.
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
+	ranged := d.written > 0 && d.validator != ""
+	if ranged {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.written))
+		req.Header.Set("If-Range", d.validator)
+	} else if d.written > 0 {
+		// Nothing to make sure the file is unchanged; start over.
+		if err := d.restart(); err != nil {
+			return false, err
+		}
+	}
+
+	resp, err := d.s.execute(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && ranged && resp.Uncompressed:
+		// The server encoded the body despite Accept-Encoding: identity, so
+		// the range is one of encoded bytes and does not line up with ours.
+		d.validator = ""
+		return true, fmt.Errorf("download of %s resumed with an encoded body", d.url)
+	case resp.StatusCode == http.StatusPartialContent && ranged:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != d.written {
+			d.validator = ""
+			return true, fmt.Errorf("download of %s resumed at the wrong offset: %q", d.url, resp.Header.Get("Content-Range"))
+		}
+		d.total = total
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && ranged:
+		// The server's file is no longer than what we have, so what we have
+		// is not a prefix of it: start over.
+		d.s.logger.Printf("Download of %s cannot resume at %d bytes, restarting\n", d.url, d.written)
+		resp.Body.Close()
+		if err := d.setValidator(""); err != nil {
+			return false, err
+		}
+		if err := d.restart(); err != nil {
+			return false, err
+		}
+		return d.attempt(ctx)
+	case resp.StatusCode == http.StatusOK:
+		if d.written > 0 {
+			// The file changed, or the server ignores ranges: it sent all of it.
+			if err := d.restart(); err != nil {
+				return false, err
+			}
+		}
+		d.total = resp.ContentLength
+		validator := resumeValidator(resp)
+		if resp.Uncompressed {
+			// Offsets into the decoded body mean nothing to the server.
+			validator = ""
+		}
+		if err := d.setValidator(validator); err != nil {
+			return false, err
+		}
+	default:
+		return false, fmt.Errorf("download of %s failed with status %s", d.url, resp.Status)
+	}
+
+	if _, err := io.Copy(d, resp.Body); err != nil {
+		if d.writeErr != nil {
+			return false, d.writeErr
+		}
+		return true, err
+	}
+	if d.total >= 0 && d.written < d.total {
+		return true, io.ErrUnexpectedEOF
+	}
+	return false, nil
+}
+
+// Write appends p to the file and the running digest, and reports progress.
+func (d *download) Write(p []byte) (int, error) {
+	n, err := d.file.Write(p)
+	d.hash.Write(p[:n])
+	d.written += int64(n)
+	if err != nil {
+		d.writeErr = err
+		return n, err
+	}
+	if d.opts.Progress != nil {
+		d.opts.Progress(d.written, d.total)
+	}
+	return n, nil
+}
+
+// restart discards what has been written so far.
+func (d *download) restart() error {
+	if err := d.file.Truncate(0); err != nil {
+		return err
+	}
+	d.hash.Reset()
+	d.written, d.total = 0, -1
+	return nil
+}
+
+// resumeValidator returns the value to send in If-Range when resuming resp's
+// body: its ETag if strong, as If-Range does not take weak ones, or else its
+// Last-Modified date. It returns "" if the body cannot be resumed.
+func resumeValidator(resp *http.Response) string {
+	if resp.Header.Get("Accept-Ranges") == "none" {
+		return ""
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// parseContentRange parses a "bytes first-last/complete" Content-Range
+// header. total is -1 if the complete length is "*".
+func parseContentRange(header string) (start, total int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	span, size, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, _, found := strings.Cut(span, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if size == "*" {
+		return start, -1, true
+	}
+	if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return 0, 0, false
+	}
+	return start, total, true
+}
@@ -0,0 +1,306 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/cftest"
+	"github.com/Advik-B/cloudscraper/lib/clearance"
+	"github.com/Advik-B/cloudscraper/lib/errors"
+	"github.com/Advik-B/cloudscraper/lib/proxy"
+)
+
+func randomContent(n int) []byte {
+	content := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(content)
+	return content
+}
+
+// leavePart writes what an interrupted earlier call would have left of a
+// download to dst: the .part file and the validator to resume it against.
+func leavePart(t *testing.T, dst string, part []byte, validator string) {
+	t.Helper()
+	if err := os.WriteFile(dst+".part", part, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst+".part"+validatorSuffix, []byte(validator), 0o666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkDownloaded checks that dst holds content and nothing of the
+// transfer is left behind.
+func checkDownloaded(t *testing.T, dst string, content []byte) {
+	t.Helper()
+	if got, err := os.ReadFile(dst); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("downloaded %d bytes (%v), want the %d byte file", len(got), err, len(content))
+	}
+	for _, leftover := range []string{dst + ".part", dst + ".part" + validatorSuffix} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind", filepath.Base(leftover))
+		}
+	}
+}
+
+// fileServer serves content with a strong ETag and range support. With
+// interrupt set, its first response breaks off half way.
+type fileServer struct {
+	content   []byte
+	gzip      bool
+	interrupt bool
+
+	mu     sync.Mutex
+	ranges []string
+	agents []string
+}
+
+func (fs *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	fs.ranges = append(fs.ranges, r.Header.Get("Range"))
+	fs.agents = append(fs.agents, r.UserAgent())
+	first := fs.interrupt && len(fs.ranges) == 1
+	fs.mu.Unlock()
+
+	w.Header().Set("ETag", `"v1"`)
+	body := fs.content
+	if fs.gzip {
+		// The server encodes its responses whatever the client asked for.
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(fs.content)
+		zw.Close()
+		body = buf.Bytes()
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	if first {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+}
+
+func TestDownloadResumesAcrossCalls(t *testing.T) {
+	content := randomContent(256 << 10)
+	sum := sha256.Sum256(content)
+	fs := &fileServer{content: content, interrupt: true}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	s := newTestScraper(t)
+	dst := filepath.Join(t.TempDir(), "file.bin")
+	opts := &DownloadOptions{SHA256: hex.EncodeToString(sum[:]), MaxResumes: -1}
+
+	if _, err := s.Download(context.Background(), srv.URL, dst, opts); err == nil {
+		t.Fatal("interrupted download succeeded")
+	}
+	partial, err := os.ReadFile(dst + ".part")
+	if err != nil || len(partial) == 0 {
+		t.Fatalf("interrupted download left no .part file: %v", err)
+	}
+
+	n, err := s.Download(context.Background(), srv.URL, dst, opts)
+	if err != nil {
+		t.Fatalf("resumed Download: %v", err)
+	}
+	got, _ := os.ReadFile(dst)
+	if n != int64(len(content)) || !bytes.Equal(got, content) {
+		t.Errorf("downloaded %d bytes, want the %d byte file", n, len(content))
+	}
+	if want := "bytes=" + strconv.Itoa(len(partial)) + "-"; fs.ranges[1] != want {
+		t.Errorf("second call sent Range %q, want %q", fs.ranges[1], want)
+	}
+	for _, leftover := range []string{dst + ".part", dst + ".part" + validatorSuffix} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind", filepath.Base(leftover))
+		}
+	}
+}
+
+func TestDownloadDoesNotResumeEncodedBody(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	fs := &fileServer{content: content, gzip: true, interrupt: true}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	s := newTestScraper(t)
+	dst := filepath.Join(t.TempDir(), "file.txt")
+	if _, err := s.Download(context.Background(), srv.URL, dst, &DownloadOptions{MaxResumes: -1}); err == nil {
+		t.Fatal("interrupted download succeeded")
+	}
+	if _, err := os.Stat(dst + ".part"); !os.IsNotExist(err) {
+		t.Error("a .part file of decoded bytes was kept for resuming")
+	}
+
+	if _, err := s.Download(context.Background(), srv.URL, dst, nil); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got, _ := os.ReadFile(dst); !bytes.Equal(got, content) {
+		t.Error("downloaded file does not match")
+	}
+	if fs.ranges[1] != "" {
+		t.Errorf("second call sent Range %q for an encoded body", fs.ranges[1])
+	}
+}
+
+func TestDownloadRestartsOn416(t *testing.T) {
+	content := randomContent(64 << 10)
+	fs := &fileServer{content: content}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	// The .part file is longer than the file: the server cannot resume it.
+	dst := filepath.Join(t.TempDir(), "file.bin")
+	leavePart(t, dst, append(randomContent(len(content)), "more"...), `"v1"`)
+
+	s := newTestScraper(t)
+	if _, err := s.Download(context.Background(), srv.URL, dst, nil); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	checkDownloaded(t, dst, content)
+	if want := []string{fmt.Sprintf("bytes=%d-", len(content)+4), ""}; fmt.Sprint(fs.ranges) != fmt.Sprint(want) {
+		t.Errorf("sent Range %q, want %q", fs.ranges, want)
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	srv := httptest.NewServer(&fileServer{content: randomContent(32 << 10)})
+	defer srv.Close()
+
+	s := newTestScraper(t)
+	dst := filepath.Join(t.TempDir(), "file.bin")
+	wrong := sha256.Sum256([]byte("something else"))
+	_, err := s.Download(context.Background(), srv.URL, dst, &DownloadOptions{SHA256: hex.EncodeToString(wrong[:])})
+	if !stderrors.Is(err, errors.ErrChecksumMismatch) {
+		t.Fatalf("got %v, want ErrChecksumMismatch", err)
+	}
+	for _, path := range []string{dst, dst + ".part", dst + ".part" + validatorSuffix} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left behind", filepath.Base(path))
+		}
+	}
+}
+
+func TestDownloadResumeChallenged(t *testing.T) {
+	content := randomContent(256 << 10)
+	fs := &fileServer{content: content}
+	srv := cftest.NewServer(cftest.Config{Challenge: cftest.Managed, Handler: fs})
+	defer srv.Close()
+
+	half := len(content) / 2
+	dst := filepath.Join(t.TempDir(), "file.bin")
+	leavePart(t, dst, content[:half], `"v1"`)
+
+	var mu sync.Mutex
+	var progress [][2]int64
+	s := newTestScraper(t)
+	_, err := s.Download(context.Background(), srv.URL+"/file.bin", dst, &DownloadOptions{
+		Progress: func(written, total int64) {
+			mu.Lock()
+			progress = append(progress, [2]int64{written, total})
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	checkDownloaded(t, dst, content)
+	if stats := srv.Stats(); stats.Solved != 1 {
+		t.Errorf("solved %d challenges, want 1", stats.Solved)
+	}
+	// The solve lands on a plain GET; the range request is then replayed.
+	if last := fs.ranges[len(fs.ranges)-1]; last != fmt.Sprintf("bytes=%d-", half) {
+		t.Errorf("last request had Range %q, want the resumed range", last)
+	}
+
+	size := int64(len(content))
+	if len(progress) == 0 || progress[0][0] <= int64(half) || progress[len(progress)-1] != [2]int64{size, size} {
+		t.Fatalf("progress %v, want it to run from past %d to %d of %d", progress, half, size, size)
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i][0] <= progress[i-1][0] {
+			t.Errorf("progress went from %d to %d", progress[i-1][0], progress[i][0])
+		}
+	}
+}
+
+// TestDownloadKeepsClearanceIdentity downloads from a host whose clearance
+// was obtained through one proxy, after the scraper has moved on to
+// another User-Agent. The transfer must present the clearance's identity,
+// so the origin lets it through without another challenge.
+func TestDownloadKeepsClearanceIdentity(t *testing.T) {
+	content := randomContent(64 << 10)
+	fs := &fileServer{content: content}
+	srv := cftest.NewServer(cftest.Config{Challenge: cftest.Managed, Handler: fs})
+	defer srv.Close()
+	origin := mustParse(t, srv.URL)
+
+	var mu sync.Mutex
+	used := map[string]int{}
+	var proxies []string
+	for p := 0; p < 3; p++ {
+		forward := httputil.NewSingleHostReverseProxy(origin)
+		fwd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			used[r.Host+" via "+strconv.Itoa(p)]++
+			mu.Unlock()
+			forward.ServeHTTP(w, r)
+		}))
+		defer fwd.Close()
+		proxies = append(proxies, fwd.URL)
+	}
+	s := newTestScraper(t, WithProxies(proxies, proxy.Sequential, time.Minute))
+
+	if _, err := getOK(s, srv.URL+"/file.bin"); err != nil {
+		t.Fatal(err)
+	}
+	c := s.Clearance(origin.Hostname())
+	if c == nil {
+		t.Fatal("no clearance after the solve")
+	}
+	_, gen := s.identity()
+	if _, err := s.rotateSession(origin, gen); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	used = map[string]int{}
+	mu.Unlock()
+
+	dst := filepath.Join(t.TempDir(), "file.bin")
+	if _, err := s.Download(context.Background(), srv.URL+"/file.bin", dst, nil); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	checkDownloaded(t, dst, content)
+	if stats := srv.Stats(); stats.Solved != 1 {
+		t.Errorf("solved %d challenges, want the clearance to be reused", stats.Solved)
+	}
+	if agent := fs.agents[len(fs.agents)-1]; agent != c.UserAgent {
+		t.Errorf("downloaded as %q, want the clearance's %q", agent, c.UserAgent)
+	}
+	var want string
+	for i, p := range proxies {
+		if u, _ := url.Parse(p); clearance.ProxyEgress(u) == c.Proxy {
+			want = origin.Host + " via " + strconv.Itoa(i)
+		}
+	}
+	if len(used) != 1 || used[want] != 1 {
+		t.Errorf("download went through %v, want only %s", used, want)
+	}
+}
@@ -0,0 +1,126 @@
+package cloudscraper
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// decodeBody replaces resp's body with one that undoes its Content-Encoding.
+// Stacked encodings such as "deflate, gzip" are undone last to first. The
+// Content-Encoding and Content-Length headers are removed and
+// resp.Uncompressed is set, as net/http does for the gzip it decodes
+// itself. A response with an encoding the scraper cannot decode is left
+// as it is.
+func decodeBody(resp *http.Response) error {
+	var codings []string
+	for _, c := range strings.Split(resp.Header.Get("Content-Encoding"), ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		switch c {
+		case "", "identity":
+		case "gzip", "x-gzip", "deflate", "br", "zstd":
+			codings = append(codings, c)
+		default:
+			return nil
+		}
+	}
+	if len(codings) == 0 {
+		return nil
+	}
+
+	body := &decodedBody{raw: resp.Body}
+	buffered := bufio.NewReader(resp.Body)
+	// Responses such as those to HEAD, or 304s, carry the header without a body.
+	if _, err := buffered.Peek(1); err == io.EOF {
+		body.Reader = buffered
+	} else {
+		var r io.Reader = buffered
+		for i := len(codings) - 1; i >= 0; i-- {
+			var err error
+			if r, err = body.decoder(codings[i], r); err != nil {
+				body.Close()
+				return fmt.Errorf("failed to decode %s response body: %w", codings[i], err)
+			}
+		}
+		body.Reader = r
+	}
+
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// decodedBody reads a response body through its decoders, and closes them
+// along with the body.
+type decodedBody struct {
+	io.Reader
+	raw     io.ReadCloser
+	closers []io.Closer
+}
+
+// decoder returns a reader that undoes coding on r.
+func (b *decodedBody) decoder(coding string, r io.Reader) (io.Reader, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		b.closers = append(b.closers, zr)
+		return zr, nil
+	case "deflate":
+		// "deflate" is meant to be zlib-wrapped, but some servers send raw
+		// deflate data, as browsers accept both.
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, err
+			}
+			b.closers = append(b.closers, zr)
+			return zr, nil
+		}
+		fr := flate.NewReader(br)
+		b.closers = append(b.closers, fr)
+		return fr, nil
+	case "br":
+		return brotli.NewReader(r), nil
+	case "zstd":
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		rc := zr.IOReadCloser()
+		b.closers = append(b.closers, rc)
+		return rc, nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", coding)
+}
+
+// Close closes the decoders, innermost first, and then the body.
+func (b *decodedBody) Close() error {
+	var errs []error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		errs = append(errs, b.closers[i].Close())
+	}
+	errs = append(errs, b.raw.Close())
+	return stderrors.Join(errs...)
+}
+
+// isZlibHeader reports whether header, the first two bytes of a deflate
+// body, is a zlib header: the deflate method with a valid check value.
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && header[0]>>4 <= 7 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
@@ -0,0 +1,140 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const plainPage = "<html><body>origin page</body></html>"
+
+// encoders compress with each Content-Encoding the scraper decodes.
+var encoders = map[string]func(io.Writer) io.WriteCloser{
+	"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+	"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+	"raw deflate": func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	},
+	"br": func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+	"zstd": func(w io.Writer) io.WriteCloser {
+		zw, _ := zstd.NewWriter(w)
+		return zw
+	},
+}
+
+// compress applies codings to data in order, as a server would for a
+// Content-Encoding listing them.
+func compress(t *testing.T, data []byte, codings ...string) []byte {
+	t.Helper()
+	for _, c := range codings {
+		var buf bytes.Buffer
+		w := encoders[c](&buf)
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data = buf.Bytes()
+	}
+	return data
+}
+
+func encodedResponse(contentEncoding string, body []byte) *http.Response {
+	header := http.Header{}
+	header.Set("Content-Encoding", contentEncoding)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentEncoding string
+		codings         []string
+	}{
+		{"gzip", "gzip", []string{"gzip"}},
+		{"x-gzip", "x-gzip", []string{"gzip"}},
+		{"zlib deflate", "deflate", []string{"deflate"}},
+		{"raw deflate", "deflate", []string{"raw deflate"}},
+		{"br", "br", []string{"br"}},
+		{"zstd", "zstd", []string{"zstd"}},
+		{"stacked", "deflate, gzip", []string{"deflate", "gzip"}},
+		{"identity listed", "identity, BR", []string{"br"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := encodedResponse(tt.contentEncoding, compress(t, []byte(plainPage), tt.codings...))
+			if err := decodeBody(resp); err != nil {
+				t.Fatalf("decodeBody: %v", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil || string(body) != plainPage {
+				t.Fatalf("decoded body %q (%v), want %q", body, err, plainPage)
+			}
+			if err := resp.Body.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+			if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Length") != "" ||
+				resp.ContentLength != -1 || !resp.Uncompressed {
+				t.Errorf("decoded response kept its encoding: header %v, length %d, uncompressed %v",
+					resp.Header, resp.ContentLength, resp.Uncompressed)
+			}
+		})
+	}
+}
+
+func TestDecodeBodyLeftAlone(t *testing.T) {
+	// HEAD responses and 304s carry the header without a body.
+	resp := encodedResponse("gzip", nil)
+	if err := decodeBody(resp); err != nil {
+		t.Fatalf("empty body: %v", err)
+	}
+	if body, err := io.ReadAll(resp.Body); err != nil || len(body) != 0 || !resp.Uncompressed {
+		t.Errorf("empty body read as %q (%v), uncompressed %v", body, err, resp.Uncompressed)
+	}
+
+	// An encoding the scraper cannot undo is passed on untouched.
+	resp = encodedResponse("gzip, compress", []byte("data"))
+	if err := decodeBody(resp); err != nil {
+		t.Fatalf("unknown encoding: %v", err)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip, compress" || resp.Uncompressed {
+		t.Errorf("response in an unknown encoding was changed: %v", resp.Header)
+	}
+
+	// A body that is not in its declared encoding fails.
+	resp = encodedResponse("gzip", []byte(plainPage))
+	if err := decodeBody(resp); err == nil {
+		t.Error("plain body declared as gzip decoded without error")
+	}
+}
+
+func TestScraperDecodesResponses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept-Encoding"); got != "gzip, deflate, br" {
+			http.Error(w, "unexpected Accept-Encoding "+got, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Encoding", "br")
+		w.Write(compress(t, []byte("origin GET"), "br"))
+	}))
+	defer srv.Close()
+
+	getOrigin(t, newTestScraper(t), srv.URL)
+}
@@ -1,6 +1,10 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 var (
 	ErrCloudflare         = errors.New("cloudflare error")
@@ -11,4 +15,196 @@ var (
 	ErrAllProxiesBanned   = errors.New("all proxies are currently banned")
 	ErrMaxRetriesExceeded = errors.New("failed after max retries")
 	ErrExecutionTimeout   = errors.New("otto: execution timed out")
+	ErrTooManyRedirects   = errors.New("too many redirects")
+	ErrChallengeRejected  = errors.New("challenge answer rejected")
+	ErrChallengeLoop      = errors.New("challenge keeps coming back")
+	ErrNoClearance        = errors.New("no cf_clearance cookie issued")
+	ErrBodyTooLarge       = errors.New("response body too large")
+	ErrChecksumMismatch   = errors.New("checksum mismatch")
+	ErrNotJSON            = errors.New("response is not JSON")
+	ErrUnexpectedStatus   = errors.New("unexpected status")
+
+	ErrBlocked                = errors.New("blocked by cloudflare")
+	ErrAccessDenied           = errors.New("access denied by firewall rule") // 1020
+	ErrRateLimited            = errors.New("rate limited")                   // 1015
+	ErrBrowserSignatureBanned = errors.New("browser signature banned")       // 1010
+	ErrIPBanned               = errors.New("ip address banned")              // 1006
+)
+
+// RetryError is returned when a request still fails after every permitted
+// attempt. It matches ErrMaxRetriesExceeded as well as the last attempt's error.
+type RetryError struct {
+	Attempts   int
+	StatusCode int   // status of the last attempt, or 0 if it failed with an error
+	Err        error // error of the last attempt, if any
+}
+
+func (e *RetryError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("%v (%d attempts): %v", ErrMaxRetriesExceeded, e.Attempts, e.Err)
+	case e.StatusCode != 0:
+		return fmt.Sprintf("%v (%d attempts): last status %d", ErrMaxRetriesExceeded, e.Attempts, e.StatusCode)
+	}
+	return fmt.Sprintf("%v (%d attempts)", ErrMaxRetriesExceeded, e.Attempts)
+}
+
+func (e *RetryError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrMaxRetriesExceeded}
+	}
+	return []error{ErrMaxRetriesExceeded, e.Err}
+}
+
+// Stage is the step of getting past a challenge at which a ChallengeError occurred.
+type Stage string
+
+const (
+	StageDetect  Stage = "detect"  // the page looks like a challenge no handler recognises
+	StageExtract Stage = "extract" // the challenge page could not be parsed
+	StageExecute Stage = "execute" // the JS engine or captcha solver failed
+	StageSubmit  Stage = "submit"  // the answer could not be submitted
+	StageVerify  Stage = "verify"  // the answer was submitted but not accepted
+)
+
+// StageAction is what was done about one challenge a request ran into.
+type StageAction string
+
+const (
+	ActionSolve    StageAction = "solve"          // solved by the handler that detected it
+	ActionReuse    StageAction = "reuse"          // let through with a stored clearance
+	ActionWait     StageAction = "wait"           // left to a concurrent solve on the same host
+	ActionCaptcha  StageAction = "captcha"        // escalated to the captcha solver
+	ActionIdentity StageAction = "fresh-identity" // escalated to a fresh browser identity
 )
+
+// ChallengeStage is one challenge a request ran into on its way to a
+// response, such as a JS challenge followed by Turnstile.
+type ChallengeStage struct {
+	Kind       string // the name of the handler that detected the challenge
+	Action     StageAction
+	URL        string
+	StatusCode int
+	RayID      string
+	Err        error // nil if the stage got through or was still in progress
+}
+
+// ChallengeError describes a failure to get past a challenge. It matches
+// ErrChallenge as well as the underlying error, so errors.Is still works with
+// sentinels such as ErrNoCaptchaSolver and ErrChallengeTimeout.
+type ChallengeError struct {
+	Kind       string // e.g. "v1", "v2", "turnstile", "hcaptcha", "recaptcha"
+	Stage      Stage
+	URL        string
+	StatusCode int    // status of the challenge response
+	RayID      string // the cf-ray header of the challenge response
+	Err        error
+	// History lists every challenge stage the request went through, in
+	// order, including the one that failed.
+	History []ChallengeStage
+}
+
+func (e *ChallengeError) Error() string {
+	kind := e.Kind
+	if kind == "" {
+		kind = "unknown"
+	}
+	msg := fmt.Sprintf("%v: %s challenge failed at %s stage", ErrChallenge, kind, e.Stage)
+	if e.URL != "" {
+		msg += " for " + e.URL
+	}
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d", e.StatusCode)
+		if e.RayID != "" {
+			msg += ", ray " + e.RayID
+		}
+		msg += ")"
+	}
+	if len(e.History) > 1 {
+		msg += fmt.Sprintf(" after %d challenge stages", len(e.History))
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ChallengeError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrChallenge}
+	}
+	return []error{ErrChallenge, e.Err}
+}
+
+// BlockError is returned when Cloudflare answers with one of its numbered
+// error pages, such as "Access denied | Error 1020", instead of a challenge.
+// It matches ErrBlocked and, for the codes listed below, a more specific
+// sentinel:
+//
+//	1006  ErrIPBanned
+//	1010  ErrBrowserSignatureBanned
+//	1015  ErrRateLimited
+//	1020  ErrAccessDenied
+type BlockError struct {
+	Code       int // the Cloudflare error code, e.g. 1020
+	URL        string
+	StatusCode int
+	RayID      string
+	RetryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *BlockError) Error() string {
+	msg := fmt.Sprintf("%v: error %d", ErrBlocked, e.Code)
+	if sentinel := e.sentinel(); sentinel != nil {
+		msg += fmt.Sprintf(" (%v)", sentinel)
+	}
+	if e.URL != "" {
+		msg += " for " + e.URL
+	}
+	msg += fmt.Sprintf(" (status %d", e.StatusCode)
+	if e.RayID != "" {
+		msg += ", ray " + e.RayID
+	}
+	return msg + ")"
+}
+
+func (e *BlockError) Unwrap() []error {
+	if sentinel := e.sentinel(); sentinel != nil {
+		return []error{ErrBlocked, sentinel}
+	}
+	return []error{ErrBlocked}
+}
+
+func (e *BlockError) sentinel() error {
+	switch e.Code {
+	case 1006:
+		return ErrIPBanned
+	case 1010:
+		return ErrBrowserSignatureBanned
+	case 1015:
+		return ErrRateLimited
+	case 1020:
+		return ErrAccessDenied
+	}
+	return nil
+}
+
+// StatusError is returned when a response comes back with a status the
+// caller cannot use, such as a 404 to a request for JSON. It matches
+// ErrUnexpectedStatus. Body holds the start of the response body, which
+// often says what went wrong.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%v %d for %s", ErrUnexpectedStatus, e.StatusCode, e.URL)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+func (e *StatusError) Unwrap() error { return ErrUnexpectedStatus }
@@ -0,0 +1,95 @@
+package cloudscraper
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
+)
+
+// challengeFlight is a challenge solve in progress for one host. Requests
+// challenged on that host while it runs wait for it instead of solving the
+// challenge again.
+type challengeFlight struct {
+	done chan struct{}
+	err  error // set before done is closed
+}
+
+// joinFlight returns the solve in progress for host, starting one if there is
+// none. leader reports whether the caller started it and must finish it.
+func (s *Scraper) joinFlight(host string) (f *challengeFlight, leader bool) {
+	s.flightMu.Lock()
+	defer s.flightMu.Unlock()
+	if f, ok := s.flights[host]; ok {
+		return f, false
+	}
+	if s.flights == nil {
+		s.flights = make(map[string]*challengeFlight)
+	}
+	f = &challengeFlight{done: make(chan struct{})}
+	s.flights[host] = f
+	return f, true
+}
+
+// flightMarker lists the flights a context is solving, innermost first.
+type flightMarker struct {
+	flight *challengeFlight
+	parent *flightMarker
+}
+
+type flightKey struct{}
+
+// withFlight marks ctx as the context of f's solve.
+func withFlight(ctx context.Context, f *challengeFlight) context.Context {
+	parent, _ := ctx.Value(flightKey{}).(*flightMarker)
+	return context.WithValue(ctx, flightKey{}, &flightMarker{flight: f, parent: parent})
+}
+
+// leadsFlight reports whether ctx belongs to f's solve, in which case waiting
+// for f would deadlock.
+func leadsFlight(ctx context.Context, f *challengeFlight) bool {
+	for m, _ := ctx.Value(flightKey{}).(*flightMarker); m != nil; m = m.parent {
+		if m.flight == f {
+			return true
+		}
+	}
+	return false
+}
+
+// finishFlight records the outcome of the leader's solve and wakes the waiters.
+func (s *Scraper) finishFlight(host string, f *challengeFlight, err error) {
+	s.flightMu.Lock()
+	delete(s.flights, host)
+	s.flightMu.Unlock()
+	f.err = err
+	close(f.done)
+}
+
+// awaitFlight waits up to Options.ChallengeWaitTimeout for another request's
+// solve to finish. It returns nil when the waiting request should be replayed:
+// after a successful solve, or when the leader gave up because its own
+// context ended, in which case the replay starts a new solve. Any other
+// failure of the shared solve is returned to every waiter.
+func (s *Scraper) awaitFlight(ctx context.Context, f *challengeFlight) error {
+	var timeout <-chan time.Time
+	if d := s.opts.ChallengeWaitTimeout; d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		return fmt.Errorf("%w: waited %v for a concurrent solve", errors.ErrChallengeTimeout, s.opts.ChallengeWaitTimeout)
+	}
+
+	if f.err == nil || stderrors.Is(f.err, context.Canceled) || stderrors.Is(f.err, context.DeadlineExceeded) {
+		return nil
+	}
+	return fmt.Errorf("concurrent challenge solve failed: %w", f.err)
+}
@@ -0,0 +1,119 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// challengeForm is the form a challenge page submits its answer with.
+type challengeForm struct {
+	// Action is the absolute URL the form is submitted to.
+	Action *url.URL
+	// Method is the upper-case HTTP method, POST unless the form says otherwise.
+	Method string
+	// Fields holds the values of every hidden input of the form.
+	Fields url.Values
+}
+
+// parseChallengeForm finds the challenge form in body, the page served for
+// page, and collects its hidden inputs. The form is the one with id or class
+// challenge-form or, failing that, the first form holding hidden inputs.
+// It returns nil if the page has no such form.
+func parseChallengeForm(page *url.URL, body []byte) *challengeForm {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+
+	var forms []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Form {
+			forms = append(forms, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	var form *html.Node
+	for _, f := range forms {
+		if attr(f, "id") == "challenge-form" || hasClass(f, "challenge-form") {
+			form = f
+			break
+		}
+	}
+	if form == nil {
+		for _, f := range forms {
+			if len(hiddenInputs(f)) > 0 {
+				form = f
+				break
+			}
+		}
+	}
+	if form == nil {
+		return nil
+	}
+
+	cf := &challengeForm{
+		Method: strings.ToUpper(attr(form, "method")),
+		Fields: url.Values{},
+	}
+	if cf.Method == "" {
+		cf.Method = "POST"
+	}
+	for _, in := range hiddenInputs(form) {
+		cf.Fields.Add(attr(in, "name"), attr(in, "value"))
+	}
+
+	action := attr(form, "action")
+	if action == "" {
+		// Managed challenges may leave the action to their script, which
+		// submits to the tokenised path from _cf_chl_opt.
+		action = parseChlOpt(body)["cUPMDTk"]
+	}
+	if cf.Action, err = page.Parse(action); err != nil {
+		return nil
+	}
+	return cf
+}
+
+// hiddenInputs returns the named hidden inputs within form.
+func hiddenInputs(form *html.Node) []*html.Node {
+	var inputs []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Input &&
+			strings.EqualFold(attr(n, "type"), "hidden") && attr(n, "name") != "" {
+			inputs = append(inputs, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(form)
+	return inputs
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
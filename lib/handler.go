@@ -0,0 +1,199 @@
+package cloudscraper
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
+)
+
+// ChallengeHandler recognises and solves one kind of anti-bot challenge.
+type ChallengeHandler interface {
+	// Detect reports whether resp, whose body has already been read into
+	// body, carries a challenge this handler can solve.
+	Detect(resp *http.Response, body []byte) bool
+	// Solve gets past the challenge and returns the response the challenge
+	// let through, typically the response to the submitted answer. The
+	// scraper's cookie jar must hold the resulting clearance afterwards,
+	// since the original request is replayed with it.
+	Solve(ctx context.Context, s *Scraper, resp *http.Response, body []byte) (*http.Response, error)
+}
+
+// Names of the built-in challenge handlers.
+const (
+	ChallengeOrchestrate = "cloudflare-orchestrate"
+	ChallengeV2          = "cloudflare-v2"
+	ChallengeV1          = "cloudflare-v1"
+	ChallengeCaptcha     = "cloudflare-captcha"
+)
+
+// ChallengeRegistry is a priority-ordered set of named ChallengeHandlers. A
+// response is handed to the highest-priority handler that detects a
+// challenge in it; handlers of equal priority are tried in registration
+// order. It is safe for concurrent use.
+type ChallengeRegistry struct {
+	mu       sync.RWMutex
+	handlers []registeredHandler
+	seq      int
+}
+
+type registeredHandler struct {
+	name     string
+	priority int
+	seq      int
+	handler  ChallengeHandler
+}
+
+// NewChallengeRegistry returns a registry holding the built-in handlers:
+// ChallengeOrchestrate at priority 350, ChallengeV2 at 300, ChallengeV1 at 200
+// and ChallengeCaptcha at 100.
+func NewChallengeRegistry() *ChallengeRegistry {
+	r := &ChallengeRegistry{}
+	r.Register(ChallengeOrchestrate, 350, orchestrateHandler{})
+	r.Register(ChallengeV2, 300, v2Handler{})
+	r.Register(ChallengeV1, 200, v1Handler{})
+	r.Register(ChallengeCaptcha, 100, captchaHandler{})
+	return r
+}
+
+// Register adds h under name, replacing any handler already registered
+// under that name.
+func (r *ChallengeRegistry) Register(name string, priority int, h ChallengeHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(name)
+	r.seq++
+	r.handlers = append(r.handlers, registeredHandler{name: name, priority: priority, seq: r.seq, handler: h})
+	sort.Slice(r.handlers, func(i, j int) bool {
+		a, b := r.handlers[i], r.handlers[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return a.seq < b.seq
+	})
+}
+
+// Unregister removes the handler registered under name, if any.
+func (r *ChallengeRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(name)
+}
+
+// Names returns the registered handler names in the order they are tried.
+func (r *ChallengeRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, len(r.handlers))
+	for i, h := range r.handlers {
+		names[i] = h.name
+	}
+	return names
+}
+
+// Detect returns the first handler, in priority order, that detects a
+// challenge in resp, and the name it is registered under. It returns a nil
+// handler if resp is not a challenge.
+func (r *ChallengeRegistry) Detect(resp *http.Response, body []byte) (string, ChallengeHandler) {
+	return r.detect(resp, body, DetectChallenge(resp, body))
+}
+
+// detect is Detect for a response already scored as d, which the built-in
+// handlers decide on instead of each scoring the response again.
+func (r *ChallengeRegistry) detect(resp *http.Response, body []byte, d Detection) (string, ChallengeHandler) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, h := range r.handlers {
+		var found bool
+		if bh, ok := h.handler.(builtinHandler); ok {
+			found = bh.detect(d, body)
+		} else {
+			found = h.handler.Detect(resp, body)
+		}
+		if found {
+			return h.name, h.handler
+		}
+	}
+	return "", nil
+}
+
+// builtinHandler is implemented by the built-in handlers, whose Detect is
+// detect on the response's Detection.
+type builtinHandler interface {
+	detect(d Detection, body []byte) bool
+}
+
+func (r *ChallengeRegistry) remove(name string) {
+	for i, h := range r.handlers {
+		if h.name == name {
+			r.handlers = append(r.handlers[:i], r.handlers[i+1:]...)
+			return
+		}
+	}
+}
+
+// v2Handler solves the modern (v2/v3) JavaScript VM challenge.
+type v2Handler struct{}
+
+func (h v2Handler) Detect(resp *http.Response, body []byte) bool {
+	return h.detect(DetectChallenge(resp, body), body)
+}
+
+func (v2Handler) detect(d Detection, body []byte) bool {
+	return d.Challenge && jsV2DetectRegex.Match(body)
+}
+
+func (v2Handler) Solve(ctx context.Context, s *Scraper, resp *http.Response, body []byte) (*http.Response, error) {
+	info := inspectChallenge(body)
+	s.logger.Printf("Modern (v2/v3) JavaScript challenge (type %q, version %q) detected. Solving with '%s'...\n", info.Type, info.Version, s.opts.JSRuntime)
+	solved, err := s.solveModernJSChallenge(ctx, resp, string(body))
+	if err != nil {
+		return nil, stageError("v2", errors.StageExecute, err)
+	}
+	return solved, nil
+}
+
+// v1Handler solves the classic IUAM JavaScript challenge.
+type v1Handler struct{}
+
+func (h v1Handler) Detect(resp *http.Response, body []byte) bool {
+	return h.detect(DetectChallenge(resp, body), body)
+}
+
+func (v1Handler) detect(d Detection, body []byte) bool {
+	// Older pages have the jschl form but not the trace image.
+	return d.Challenge && d.Has(SignalJSChl)
+}
+
+func (v1Handler) Solve(ctx context.Context, s *Scraper, resp *http.Response, body []byte) (*http.Response, error) {
+	s.logger.Printf("Classic (v1) JavaScript challenge detected. Solving with '%s'...\n", s.opts.JSRuntime)
+	solved, err := s.solveClassicJSChallenge(ctx, resp.Request.URL, string(body))
+	if err != nil {
+		return nil, stageError("v1", errors.StageExecute, err)
+	}
+	return solved, nil
+}
+
+// captchaHandler solves Turnstile, hCaptcha and reCAPTCHA challenges through the
+// scraper's CaptchaSolver.
+type captchaHandler struct{}
+
+func (h captchaHandler) Detect(resp *http.Response, body []byte) bool {
+	return h.detect(DetectChallenge(resp, body), body)
+}
+
+func (captchaHandler) detect(d Detection, body []byte) bool {
+	return d.Challenge && d.Has(SignalSiteKey)
+}
+
+func (captchaHandler) Solve(ctx context.Context, s *Scraper, resp *http.Response, body []byte) (*http.Response, error) {
+	kind := captchaKind(string(body))
+	s.logger.Printf("Captcha challenge (%s) detected...\n", kind)
+	solved, err := s.solveCaptchaChallenge(ctx, resp, string(body), inspectChallenge(body).SiteKey)
+	if err != nil {
+		return nil, stageError(kind, errors.StageExecute, err)
+	}
+	return solved, nil
+}
@@ -0,0 +1,249 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ChallengeInfo describes a challenge page, mostly as configured by the
+// window._cf_chl_opt object Cloudflare's challenge scripts read. Fields the
+// page does not carry are empty.
+type ChallengeInfo struct {
+	// Type is cType: "managed", "non-interactive" or "interactive" on
+	// current pages.
+	Type string
+	// Ray is cRay, falling back to the Cf-Ray header.
+	Ray string
+	// Hash is cHash, a per-challenge value the scripts mix into their answer.
+	Hash string
+	// TokenPath is cUPMDTk, the path with the __cf_chl_tk token that the
+	// challenge is submitted to.
+	TokenPath string
+	// Version is cvId, the challenge platform version.
+	Version string
+	// Zone is cZone, the Cloudflare zone serving the challenge.
+	Zone string
+	// OrchestratePath is the path of the challenge-platform orchestrate
+	// script the page loads.
+	OrchestratePath string
+	// SiteKey is the Turnstile sitekey, from chlApiSitekey or an embedded widget.
+	SiteKey string
+	// Options holds every property of _cf_chl_opt by name, including those
+	// with a field above. Nested objects and arrays are kept as their source
+	// text.
+	Options map[string]string
+	// Detection is the challenge detection result for the response.
+	Detection Detection
+}
+
+var (
+	chlOptStartRegex     = regexp.MustCompile(`_cf_chl_opt\s*=\s*\{`)
+	orchestratePathRegex = regexp.MustCompile(`/cdn-cgi/challenge-platform/[^"'\s?]*orchestrate/[^"'\s?]*`)
+)
+
+// InspectChallenge describes the challenge page resp carries. It reads resp's
+// body and replaces it with an in-memory copy, so resp can still be read
+// afterwards. A response that is not a challenge yields a ChallengeInfo with
+// Detection.Challenge false rather than an error.
+func InspectChallenge(resp *http.Response) (*ChallengeInfo, error) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	info := inspectChallenge(body)
+	info.Detection = DetectChallenge(resp, body)
+	if info.Ray == "" {
+		info.Ray = resp.Header.Get("Cf-Ray")
+	}
+	return info, nil
+}
+
+// inspectChallenge extracts what it can of a ChallengeInfo from a challenge page.
+func inspectChallenge(body []byte) *ChallengeInfo {
+	opts := parseChlOpt(body)
+	info := &ChallengeInfo{
+		Type:      opts["cType"],
+		Ray:       opts["cRay"],
+		Hash:      opts["cHash"],
+		TokenPath: opts["cUPMDTk"],
+		Version:   opts["cvId"],
+		Zone:      opts["cZone"],
+		SiteKey:   opts["chlApiSitekey"],
+		Options:   opts,
+	}
+	if m := orchestratePathRegex.Find(body); m != nil {
+		info.OrchestratePath = string(m)
+	}
+	if info.SiteKey == "" {
+		if m := captchaDetectRegex.FindSubmatch(body); m != nil {
+			info.SiteKey = string(m[1])
+		}
+	}
+	return info
+}
+
+// parseChlOpt parses the object literal assigned to window._cf_chl_opt. It
+// returns an empty map if the page has none.
+func parseChlOpt(body []byte) map[string]string {
+	opts := make(map[string]string)
+	loc := chlOptStartRegex.FindIndex(body)
+	if loc == nil {
+		return opts
+	}
+
+	p := &jsObjectScanner{src: body, pos: loc[1]}
+	for {
+		p.skipSpace()
+		if p.done() || p.peek() == '}' {
+			return opts
+		}
+		key, ok := p.key()
+		if !ok {
+			return opts
+		}
+		p.skipSpace()
+		if p.done() || p.peek() != ':' {
+			return opts
+		}
+		p.pos++
+		p.skipSpace()
+		value, ok := p.value()
+		if !ok {
+			return opts
+		}
+		opts[key] = value
+		p.skipSpace()
+		if !p.done() && p.peek() == ',' {
+			p.pos++
+		}
+	}
+}
+
+// jsObjectScanner reads the properties of a JavaScript object literal, just
+// enough of the syntax for the literals Cloudflare embeds in its pages.
+type jsObjectScanner struct {
+	src []byte
+	pos int
+}
+
+func (p *jsObjectScanner) done() bool { return p.pos >= len(p.src) }
+func (p *jsObjectScanner) peek() byte { return p.src[p.pos] }
+
+func (p *jsObjectScanner) skipSpace() {
+	for !p.done() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+		p.pos++
+	}
+}
+
+// key reads a property name, bare or quoted.
+func (p *jsObjectScanner) key() (string, bool) {
+	if c := p.peek(); c == '\'' || c == '"' {
+		return p.str()
+	}
+	start := p.pos
+	for !p.done() && isIdentByte(p.peek()) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos]), p.pos > start
+}
+
+// value reads a property value: a string, a nested object or array, kept as
+// its source text, or a bare literal such as a number.
+func (p *jsObjectScanner) value() (string, bool) {
+	switch c := p.peek(); c {
+	case '\'', '"':
+		return p.str()
+	case '{', '[':
+		start := p.pos
+		if !p.skipNested() {
+			return "", false
+		}
+		return string(p.src[start:p.pos]), true
+	}
+	start := p.pos
+	for !p.done() && strings.IndexByte(",}\r\n", p.peek()) < 0 {
+		p.pos++
+	}
+	return strings.TrimSpace(string(p.src[start:p.pos])), p.pos > start
+}
+
+// str reads a quoted string, decoding its escapes.
+func (p *jsObjectScanner) str() (string, bool) {
+	quote := p.peek()
+	p.pos++
+	var sb strings.Builder
+	for !p.done() {
+		c := p.peek()
+		p.pos++
+		switch {
+		case c == quote:
+			return sb.String(), true
+		case c == '\\' && !p.done():
+			sb.WriteString(p.escape())
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+// escape decodes the escape sequence after a backslash.
+func (p *jsObjectScanner) escape() string {
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'n':
+		return "\n"
+	case 't':
+		return "\t"
+	case 'r':
+		return "\r"
+	case 'x', 'u':
+		n := 2
+		if c == 'u' {
+			n = 4
+		}
+		if p.pos+n <= len(p.src) {
+			if r, err := strconv.ParseUint(string(p.src[p.pos:p.pos+n]), 16, 32); err == nil {
+				p.pos += n
+				return string(rune(r))
+			}
+		}
+	}
+	return string(c)
+}
+
+// skipNested skips a balanced object or array, minding strings within it.
+func (p *jsObjectScanner) skipNested() bool {
+	depth := 0
+	for !p.done() {
+		switch p.peek() {
+		case '\'', '"':
+			if _, ok := p.str(); !ok {
+				return false
+			}
+			continue
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+		}
+		p.pos++
+		if depth == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -1,9 +1,12 @@
 package js
 
+import "context"
+
 // Engine defines the interface for a JavaScript runtime.
 type Engine interface {
 	// Run executes a self-contained JavaScript script and returns the result from stdout.
-	Run(script string) (string, error)
+	// Execution is aborted when ctx is cancelled or its deadline expires.
+	Run(ctx context.Context, script string) (string, error)
 }
 
 // Runtime represents the name of a supported JavaScript runtime.
@@ -2,9 +2,11 @@ package js
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
 	"strings"
+	"time"
 )
 
 // ExternalEngine uses an external command-line JS runtime (node, deno, bun).
@@ -30,17 +32,23 @@ func NewExternalEngine(command string) (*ExternalEngine, error) {
 }
 
 // Run executes a script by piping it to the external runtime's stdin.
-func (e *ExternalEngine) Run(script string) (string, error) {
+// The child process is killed if ctx is cancelled before it exits.
+func (e *ExternalEngine) Run(ctx context.Context, script string) (string, error) {
 	// Security: The `e.Command` field is sanitized in the constructor (NewExternalEngine),
 	// making this call safe from command injection.
-	cmd := exec.Command(e.Command)
+	cmd := exec.CommandContext(ctx, e.Command)
 	cmd.Stdin = strings.NewReader(script)
+	// Don't let a grandchild holding our pipes open keep Wait blocked after a kill.
+	cmd.WaitDelay = 1 * time.Second
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", fmt.Errorf("external js runtime '%s' aborted: %w", e.Command, ctxErr)
+	}
 	if err != nil {
 		return "", fmt.Errorf("external js runtime '%s' failed with exit error: %w. Stderr: %s", e.Command, err, stderr.String())
 	}
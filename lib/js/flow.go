@@ -0,0 +1,185 @@
+package js
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The DOM shim a flow's page runs in.
+//
+//go:embed flow.js
+var flowScript string
+
+// MaxFlowRequests bounds the requests a single flow may make.
+const MaxFlowRequests = 32
+
+// Script is one script of a challenge page, either inline or loaded from Src.
+type Script struct {
+	Src  string `json:"src,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+// Element is an element of a challenge page that scripts may look up by id,
+// such as a hidden form input.
+type Element struct {
+	Tag   string `json:"tag"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Text  string `json:"text"`
+}
+
+// Page is a challenge page to run as a flow.
+type Page struct {
+	URL       *url.URL
+	UserAgent string
+	Scripts   []Script
+	// Elements maps element ids to the elements scripts may look up.
+	Elements map[string]Element
+}
+
+// Request is a request a page's scripts make: an XMLHttpRequest, or the load
+// of a script element's source.
+type Request struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Script  bool              `json:"script"`
+}
+
+// Response is the answer to a Request.
+type Response struct {
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+// Fetcher performs the requests a flow's scripts make. The request's URL may
+// be relative to the page.
+type Fetcher func(ctx context.Context, req Request) (Response, error)
+
+// FlowResult is how a page's scripts left it once they stopped making requests.
+type FlowResult struct {
+	// Submit is the id of the form the scripts submitted, if any.
+	Submit string `json:"submit"`
+	// Fields holds the values of the page's named elements, as the scripts
+	// left them.
+	Fields map[string]string `json:"fields"`
+	// Location is the URL the scripts navigated to, or the page URL.
+	Location string `json:"location"`
+	// Reload reports whether the scripts reloaded the page.
+	Reload bool `json:"reload"`
+	// Cookies holds the cookies the scripts set through document.cookie.
+	Cookies []string `json:"cookies"`
+	// Errors holds the messages of exceptions the scripts raised.
+	Errors []string `json:"errors"`
+}
+
+// flowState is what one run of a flow reports: its result, and the request
+// that halted it if there is one.
+type flowState struct {
+	FlowResult
+	Fetch *Request `json:"fetch"`
+}
+
+// RunFlow runs page's scripts in engine until they stop making requests, and
+// reports how they left the page. Requests are performed with fetch, which
+// lets the scripts talk to the challenge platform through the caller's own
+// client. Since Engine runs a script to completion, each request ends a run:
+// the page is run again from the start with the requests made so far
+// answered from their recorded responses, which assumes the scripts make
+// the same requests in the same order each time. To keep that so for
+// scripts that use them, Math.random is seeded and the clock is frozen at
+// the flow's start for the whole flow, moving only with its virtual timers.
+// A flow making n requests is thus run n+1 times, replaying n(n+1)/2
+// responses in all, which MaxFlowRequests keeps in bounds.
+func RunFlow(ctx context.Context, engine Engine, page Page, fetch Fetcher) (*FlowResult, error) {
+	var responses []Response
+	env := flowEnv{
+		Seed:  1 + rand.Int63n(flowSeedModulus-1),
+		Start: time.Now().UnixMilli(),
+	}
+	for {
+		state, err := runFlowOnce(ctx, engine, page, env, responses)
+		if err != nil {
+			return nil, err
+		}
+		if state.Fetch == nil {
+			return &state.FlowResult, nil
+		}
+		if len(responses) == MaxFlowRequests {
+			return nil, fmt.Errorf("challenge flow made more than %d requests", MaxFlowRequests)
+		}
+
+		resp, err := fetch(ctx, *state.Fetch)
+		if err != nil {
+			return nil, fmt.Errorf("challenge flow request to %s failed: %w", state.Fetch.URL, err)
+		}
+		responses = append(responses, resp)
+	}
+}
+
+// flowSeedModulus is the modulus of the shim's Math.random generator.
+const flowSeedModulus = 2147483647
+
+// flowEnv is what every run of a flow must see the same: the seed of
+// Math.random, and the time in milliseconds the clock is frozen at.
+type flowEnv struct {
+	Seed  int64
+	Start int64
+}
+
+// runFlowOnce runs page's scripts with the recorded responses.
+func runFlowOnce(ctx context.Context, engine Engine, page Page, env flowEnv, responses []Response) (*flowState, error) {
+	elements := page.Elements
+	if elements == nil {
+		elements = map[string]Element{}
+	}
+	if responses == nil {
+		responses = []Response{}
+	}
+	flow, err := json.Marshal(map[string]interface{}{
+		"url":       page.URL.String(),
+		"origin":    page.URL.Scheme + "://" + page.URL.Host,
+		"pathname":  page.URL.EscapedPath(),
+		"search":    searchOf(page.URL),
+		"userAgent": page.UserAgent,
+		"scripts":   page.Scripts,
+		"elements":  elements,
+		"responses": responses,
+		"seed":      env.Seed,
+		"start":     env.Start,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	script := "var __flow = " + string(flow) + ";\n" + flowScript + "\n__run();\n"
+	out, err := engine.Run(ctx, script)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the last line is the shim's; the page's scripts may log too.
+	out = strings.TrimSpace(out)
+	if i := strings.LastIndexByte(out, '\n'); i >= 0 {
+		out = out[i+1:]
+	}
+	var state flowState
+	if err := json.Unmarshal([]byte(out), &state); err != nil {
+		return nil, fmt.Errorf("challenge flow produced no result: %w", err)
+	}
+	return &state, nil
+}
+
+func searchOf(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+	return "?" + u.RawQuery
+}
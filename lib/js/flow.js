@@ -0,0 +1,243 @@
+// DOM shim for running a challenge page's scripts as a flow. It expects
+// __flow to hold the page URL, its scripts, the elements its inputs carry,
+// the responses to the requests made in earlier runs, and the seed and start
+// time every run shares. Requests are
+// answered from __flow.responses in the order they are made; the first one
+// without a response halts the page and is reported so the Go side can
+// perform it and run the page again.
+var window = (typeof globalThis !== 'undefined') ? globalThis : this;
+
+window.__state = { fetch: null, submit: null, reload: false, cookies: [], errors: [] };
+window.__halted = false;
+window.__fetches = 0;
+
+// Timers run in virtual time, in due order, once the page's scripts are done.
+window.__timers = [];
+window.__now = 0;
+window.__timerSeq = 0;
+window.setTimeout = function(fn, delay) {
+    var id = ++window.__timerSeq;
+    window.__timers.push({ id: id, fn: fn, due: window.__now + (delay || 0) });
+    return id;
+};
+window.setInterval = window.setTimeout;
+window.clearTimeout = function(id) {
+    for (var i = 0; i < window.__timers.length; i++) {
+        if (window.__timers[i].id === id) {
+            window.__timers.splice(i, 1);
+            return;
+        }
+    }
+};
+window.clearInterval = window.clearTimeout;
+
+// Each run must make the same requests as the last, so Math.random is a
+// generator seeded the same way every run, and the clock stands at the
+// flow's start plus the virtual time its timers have taken.
+window.__seed = __flow.seed;
+Math.random = function() {
+    window.__seed = window.__seed * 48271 % 2147483647;
+    return (window.__seed - 1) / 2147483646;
+};
+window.__clock = function() {
+    return __flow.start + window.__now;
+};
+(function(RealDate) {
+    var FlowDate = function(y, m, d, h, min, s, ms) {
+        var n = arguments.length;
+        if (!(this instanceof FlowDate)) {
+            return new RealDate(window.__clock()).toString();
+        }
+        if (n === 0) return new RealDate(window.__clock());
+        if (n === 1) return new RealDate(y);
+        return new RealDate(y, m, n > 2 ? d : 1, n > 3 ? h : 0, n > 4 ? min : 0, n > 5 ? s : 0, n > 6 ? ms : 0);
+    };
+    FlowDate.prototype = RealDate.prototype;
+    FlowDate.now = window.__clock;
+    FlowDate.parse = RealDate.parse;
+    FlowDate.UTC = RealDate.UTC;
+    window.Date = FlowDate;
+}(Date));
+window.performance = { now: function() { return window.__now; } };
+
+window.__try = function(fn) {
+    try {
+        fn();
+    } catch (e) {
+        window.__state.errors.push(String(e && e.message || e));
+    }
+};
+
+// __request answers the next request from __flow.responses, or records it
+// and halts the page.
+window.__request = function(req) {
+    if (window.__halted) {
+        return null;
+    }
+    var i = window.__fetches++;
+    if (i < __flow.responses.length) {
+        return __flow.responses[i];
+    }
+    window.__state.fetch = req;
+    window.__halted = true;
+    return null;
+};
+
+window.__runScript = function(src) {
+    window.__try(function() { (0, eval)(src); });
+};
+
+window.XMLHttpRequest = function() {
+    this.readyState = 0;
+    this.status = 0;
+    this.responseText = '';
+    this.response = '';
+    this.headers = {};
+};
+window.XMLHttpRequest.prototype.open = function(method, url) {
+    this.method = String(method).toUpperCase();
+    this.url = String(url);
+    this.readyState = 1;
+};
+window.XMLHttpRequest.prototype.setRequestHeader = function(name, value) {
+    this.headers[name] = String(value);
+};
+window.XMLHttpRequest.prototype.getResponseHeader = function(name) {
+    return null;
+};
+window.XMLHttpRequest.prototype.send = function(body) {
+    var res = window.__request({ method: this.method, url: this.url, headers: this.headers, body: body == null ? '' : String(body) });
+    if (res === null) {
+        return;
+    }
+    var xhr = this;
+    window.setTimeout(function() {
+        xhr.readyState = 4;
+        xhr.status = res.status;
+        xhr.responseText = xhr.response = res.body;
+        if (typeof xhr.onreadystatechange === 'function') xhr.onreadystatechange();
+        if (typeof xhr.onload === 'function') xhr.onload();
+    }, 0);
+};
+
+window.__element = function(tag, id) {
+    var el = {
+        tagName: String(tag).toUpperCase(),
+        id: id || '',
+        value: '',
+        innerHTML: '',
+        textContent: '',
+        style: {},
+        attributes: {},
+        children: [],
+        firstChild: { href: __flow.origin + '/' },
+        setAttribute: function(name, value) { this.attributes[name] = String(value); if (name === 'src') this.src = String(value); },
+        getAttribute: function(name) { return this.attributes.hasOwnProperty(name) ? this.attributes[name] : null; },
+        addEventListener: function() {},
+        appendChild: function(child) {
+            this.children.push(child);
+            if (child && child.tagName === 'SCRIPT' && child.src) {
+                window.__loadScript(child, true);
+            }
+            return child;
+        },
+        submit: function() {
+            if (!window.__halted) {
+                window.__state.submit = this.id;
+                window.__halted = true;
+            }
+        }
+    };
+    return el;
+};
+
+// __loadScript fetches and runs the script element el's source, either
+// straight away, as for a script in the page, or once the scripts that are
+// running finish, as for an appended one.
+window.__loadScript = function(el, async) {
+    var res = window.__request({ method: 'GET', url: el.src, headers: {}, body: '', script: true });
+    if (res === null) {
+        return;
+    }
+    var run = function() {
+        if (res.status >= 200 && res.status < 300) {
+            window.__runScript(res.body);
+            if (typeof el.onload === 'function') el.onload();
+        } else if (typeof el.onerror === 'function') {
+            el.onerror();
+        }
+    };
+    if (async) {
+        window.setTimeout(run, 0);
+    } else {
+        run();
+    }
+};
+
+window.__root = window.__element('html');
+window.document = {
+    readyState: 'complete',
+    elements: {},
+    getElementById: function(id) {
+        if (!this.elements[id]) {
+            var known = __flow.elements[id];
+            var el = window.__element(known ? known.tag : 'div', id);
+            if (known) {
+                el.name = known.name;
+                el.value = known.value;
+                el.innerHTML = el.textContent = known.text;
+            }
+            this.elements[id] = el;
+        }
+        return this.elements[id];
+    },
+    createElement: function(tag) { return window.__element(tag); },
+    getElementsByTagName: function(tag) { return [window.__root]; },
+    querySelector: function(sel) { return sel.charAt(0) === '#' ? this.getElementById(sel.substring(1)) : null; },
+    addEventListener: function() {}
+};
+window.document.head = window.document.body = window.document.documentElement = window.__root;
+Object.defineProperty(window.document, 'cookie', {
+    get: function() { return window.__state.cookies.join('; '); },
+    set: function(v) { window.__state.cookies.push(String(v)); }
+});
+
+window.location = {
+    href: __flow.url,
+    origin: __flow.origin,
+    hash: '',
+    search: __flow.search,
+    pathname: __flow.pathname,
+    reload: function() { if (!window.__halted) { window.__state.reload = true; window.__halted = true; } },
+    assign: function(u) { this.href = String(u); },
+    replace: function(u) { this.href = String(u); }
+};
+window.navigator = { userAgent: __flow.userAgent, language: 'en-US', languages: ['en-US', 'en'], webdriver: false, cookieEnabled: true };
+window.self = window.top = window.parent = window;
+window.addEventListener = function() {};
+
+window.__run = function() {
+    for (var i = 0; i < __flow.scripts.length && !window.__halted; i++) {
+        var s = __flow.scripts[i];
+        if (s.src) {
+            window.__loadScript({ tagName: 'SCRIPT', src: s.src }, false);
+        } else {
+            window.__runScript(s.text);
+        }
+    }
+    for (var n = 0; window.__timers.length && !window.__halted && n < 10000; n++) {
+        window.__timers.sort(function(a, b) { return a.due - b.due || a.id - b.id; });
+        var t = window.__timers.shift();
+        window.__now = t.due;
+        if (typeof t.fn === 'function') window.__try(t.fn);
+    }
+
+    var fields = {};
+    for (var id in window.document.elements) {
+        var el = window.document.elements[id];
+        if (el.name) fields[el.name] = String(el.value);
+    }
+    window.__state.fields = fields;
+    window.__state.location = typeof window.location === 'string' ? window.location : String(window.location.href);
+    console.log(JSON.stringify(window.__state));
+};
@@ -0,0 +1,66 @@
+package js
+
+import (
+	"context"
+	"net/url"
+	"os/exec"
+	"testing"
+)
+
+// TestRunFlowReplaysRandomAndDate runs a page whose requests carry values
+// from Math.random and the clock. The flow is replayed after each request,
+// so the values must come out the same in every run for the page to repeat
+// its first request's token in the second.
+func TestRunFlowReplaysRandomAndDate(t *testing.T) {
+	engines := map[string]Engine{"otto": NewOttoEngine()}
+	if _, err := exec.LookPath(string(Node)); err == nil {
+		node, err := NewExternalEngine(string(Node))
+		if err != nil {
+			t.Fatalf("NewExternalEngine: %v", err)
+		}
+		engines["node"] = node
+	}
+
+	page := Page{
+		URL: &url.URL{Scheme: "https", Host: "example.com", Path: "/"},
+		Scripts: []Script{{Text: `
+			var token = Math.random() + ':' + Date.now() + ':' + new Date().getTime();
+			function post(body, done) {
+				var x = new XMLHttpRequest();
+				x.open('POST', '/flow', true);
+				x.onload = done;
+				x.send(body);
+			}
+			post(token, function() {
+				setTimeout(function() {
+					post(token + '|' + (Date.now() - start), function() {
+						document.getElementById('challenge-form').submit();
+					});
+				}, 1000);
+			});
+			var start = Date.now();
+		`}},
+	}
+	for name, engine := range engines {
+		t.Run(name, func(t *testing.T) {
+			var bodies []string
+			fetch := func(ctx context.Context, req Request) (Response, error) {
+				bodies = append(bodies, req.Body)
+				return Response{Status: 200}, nil
+			}
+			res, err := RunFlow(context.Background(), engine, page, fetch)
+			if err != nil {
+				t.Fatalf("RunFlow: %v", err)
+			}
+			if res.Submit != "challenge-form" || len(res.Errors) > 0 {
+				t.Fatalf("flow ended with submit %q, errors %v", res.Submit, res.Errors)
+			}
+			if len(bodies) != 2 {
+				t.Fatalf("flow made %d requests, want 2", len(bodies))
+			}
+			if want := bodies[0] + "|1000"; bodies[1] != want {
+				t.Errorf("second request sent %q, want %q", bodies[1], want)
+			}
+		})
+	}
+}
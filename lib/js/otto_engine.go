@@ -1,6 +1,7 @@
 package js
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"github.com/Advik-B/cloudscraper/lib/errors"
@@ -25,12 +26,12 @@ func NewOttoEngine() *OttoEngine {
 }
 
 // Run executes a script in otto. It captures output by overriding console.log.
-func (e *OttoEngine) Run(script string) (string, error) {
+// The VM is interrupted if ctx is cancelled or the execution time limit is hit.
+func (e *OttoEngine) Run(ctx context.Context, script string) (result string, err error) {
 	vm := otto.New()
-	var result string
 
 	// Setup safe console.log capturing
-	err := vm.Set("console", map[string]interface{}{
+	err = vm.Set("console", map[string]interface{}{
 		"log": func(call otto.FunctionCall) otto.Value {
 			result = call.Argument(0).String()
 			return otto.Value{}
@@ -40,31 +41,13 @@ func (e *OttoEngine) Run(script string) (string, error) {
 		return "", fmt.Errorf("otto: failed to set console.log: %w", err)
 	}
 
-	// === Hardened Execution ===
-	const maxExecutionTime = 3 * time.Second
-	vm.Interrupt = make(chan func(), 1)
-	watchdogDone := make(chan struct{})
-	defer close(watchdogDone)
-
-	// Watchdog goroutine to interrupt runaway code
-	go func() {
-		select {
-		case <-time.After(maxExecutionTime):
-			vm.Interrupt <- func() {
-				panic(errors.ErrExecutionTimeout)
-			}
-		case <-watchdogDone:
-		}
-	}()
+	stop := watchVM(ctx, vm)
+	defer stop()
 
 	// Recover from intentional interrupts
 	defer func() {
 		if r := recover(); r != nil {
-			if r == errors.ErrExecutionTimeout {
-				err = fmt.Errorf("otto: script execution timed out after %v", maxExecutionTime)
-			} else {
-				panic(r) // Bubble up unexpected panics
-			}
+			result, err = "", interruptError(r)
 		}
 	}()
 
@@ -75,38 +58,132 @@ func (e *OttoEngine) Run(script string) (string, error) {
 	return result, nil
 }
 
+// maxExecutionTime bounds a single otto script run, regardless of the caller's context.
+const maxExecutionTime = 3 * time.Second
+
+// vmInterrupt is the panic value used to unwind an interrupted otto VM.
+type vmInterrupt struct {
+	err error
+}
+
+// watchVM starts a watchdog that interrupts vm when ctx is done or the
+// execution time limit elapses. The returned function stops the watchdog.
+func watchVM(ctx context.Context, vm *otto.Otto) (stop func()) {
+	vm.Interrupt = make(chan func(), 1)
+	done := make(chan struct{})
+
+	go func() {
+		var cause error
+		select {
+		case <-time.After(maxExecutionTime):
+			cause = errors.ErrExecutionTimeout
+		case <-ctx.Done():
+			cause = ctx.Err()
+		case <-done:
+			return
+		}
+		vm.Interrupt <- func() {
+			panic(vmInterrupt{err: cause})
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// interruptError converts a recovered watchdog panic into an error and
+// re-panics on anything else.
+func interruptError(r interface{}) error {
+	intr, ok := r.(vmInterrupt)
+	if !ok {
+		panic(r) // Bubble up unexpected panics
+	}
+	if intr.err == errors.ErrExecutionTimeout {
+		return fmt.Errorf("otto: script execution timed out after %v: %w", maxExecutionTime, intr.err)
+	}
+	return fmt.Errorf("otto: script execution aborted: %w", intr.err)
+}
+
 // SolveV2Challenge uses the original synchronous method to solve v2 challenges,
-// as otto does not support asynchronous operations like setTimeout.
-func (e *OttoEngine) SolveV2Challenge(body, domain string, scriptMatches [][]string, logger *log.Logger) (string, error) {
+// as otto does not support asynchronous operations like setTimeout. Timers
+// registered by the challenge are queued by the DOM shim and flushed after the wait.
+// The wait for the challenge's timers is cut short if ctx is cancelled.
+func (e *OttoEngine) SolveV2Challenge(ctx context.Context, body, domain string, scriptMatches [][]string, logger *log.Logger) (string, error) {
 	vm := otto.New()
 
+	if err := vm.Set("__domain", domain); err != nil {
+		return "", fmt.Errorf("otto: failed to set domain: %w", err)
+	}
 	// Security: Running setup script in VM.
 	if _, err := vm.Run(setupScript); err != nil {
 		return "", fmt.Errorf("otto: failed to set up DOM shim: %w", err)
 	}
 
 	// Execute all extracted Cloudflare scripts in the same VM context.
-	for _, match := range scriptMatches {
-		if len(match) > 1 {
-			scriptContent := match[1]
-			scriptContent = strings.ReplaceAll(scriptContent, `document.getElementById('challenge-form');`, "({})")
-			// Security: This executes JavaScript from the Cloudflare challenge page.
-			// The otto VM is sandboxed, but this is an inherent risk of the library's function.
-			if _, err := vm.Run(scriptContent); err != nil {
-				logger.Printf("otto: warning, a script block failed to run: %v\n", err)
+	err := runGuarded(ctx, vm, func() error {
+		for _, match := range scriptMatches {
+			if len(match) > 1 {
+				scriptContent := match[1]
+				scriptContent = strings.ReplaceAll(scriptContent, `document.getElementById('challenge-form');`, "({})")
+				// Security: This executes JavaScript from the Cloudflare challenge page.
+				// The otto VM is sandboxed, but this is an inherent risk of the library's function.
+				if _, err := vm.Run(scriptContent); err != nil {
+					logger.Printf("otto: warning, a script block failed to run: %v\n", err)
+				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
 
 	// Wait for the script's internal timeouts to complete.
-	time.Sleep(4 * time.Second)
+	select {
+	case <-time.After(4 * time.Second):
+	case <-ctx.Done():
+		return "", fmt.Errorf("otto: challenge wait aborted: %w", ctx.Err())
+	}
+
+	err = runGuarded(ctx, vm, func() error {
+		if _, err := vm.Run(`__runTimers()`); err != nil {
+			logger.Printf("otto: warning, a challenge timer failed to run: %v\n", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
 
 	// Get the final answer from the 'jschl_answer' field in the dummy document.
-	// Security: This executes a small, controlled script to retrieve a value.
-	answerObj, err := vm.Run(`document.getElementById('jschl-answer').value`)
-	if err != nil || !answerObj.IsString() {
+	// The challenge may have replaced the field's value with a getter, so
+	// reading it runs the page's code too.
+	var answerObj otto.Value
+	err = runGuarded(ctx, vm, func() error {
+		var err error
+		answerObj, err = vm.Run(`document.getElementById('jschl-answer').value`)
+		return err
+	})
+	if err != nil {
 		return "", fmt.Errorf("otto: could not retrieve final answer from VM: %w", err)
 	}
+	if !answerObj.IsString() {
+		return "", fmt.Errorf("otto: final answer is not a string: %v", answerObj)
+	}
 
 	return answerObj.String(), nil
 }
+
+// runGuarded calls fn with the watchdog armed on vm, converting an
+// interrupt into an error.
+func runGuarded(ctx context.Context, vm *otto.Otto, fn func() error) (err error) {
+	stop := watchVM(ctx, vm)
+	defer stop()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = interruptError(r)
+		}
+	}()
+
+	return fn()
+}
@@ -0,0 +1,33 @@
+package js
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// TestOttoAnswerGetterIsGuarded runs a challenge that turns the answer
+// field's value into a getter that never returns. Reading the answer must
+// give up with the context rather than hang.
+func TestOttoAnswerGetterIsGuarded(t *testing.T) {
+	script := `Object.defineProperty(document.getElementById('jschl-answer'), 'value', {get: function() { for (;;) {} }});`
+	ctx, cancel := context.WithTimeout(context.Background(), 4500*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewOttoEngine().SolveV2Challenge(ctx, "", "example.com", [][]string{{"", script}}, log.New(io.Discard, "", 0))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !stderrors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want the context's deadline", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("reading the answer hung past the context's deadline")
+	}
+}
@@ -1,12 +1,27 @@
 var window = this;
 var navigator = { userAgent: "" };
+// otto has no event loop, so timers are queued and flushed by __runTimers
+// once the Go side has waited out the challenge delay.
+var __timers = [];
+var setTimeout = function(fn, delay) {
+    __timers.push(fn);
+    return __timers.length;
+};
+var __runTimers = function() {
+    while (__timers.length) {
+        var fn = __timers.shift();
+        if (typeof fn === 'function') fn();
+    }
+};
 var document = {
+    elements: {},
     getElementById: function(id) {
-        return { value: "" };
+        if (!this.elements[id]) this.elements[id] = { value: "" };
+        return this.elements[id];
     },
     createElement: function(tag) {
         return {
-            firstChild: { href: "https://` + domain + `/" }
+            firstChild: { href: "https://" + __domain + "/" }
         };
     },
     cookie: ""
@@ -23,4 +38,4 @@ var atob = function(str) {
         if (d != 64) result += String.fromCharCode(a);
     } while (i < str.length);
     return result;
-};
\ No newline at end of file
+};
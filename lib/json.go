@@ -0,0 +1,90 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
+)
+
+// GetJSON performs a GET request for JSON bound to ctx and decodes the
+// response into a T. A challenge or block page served in place of the JSON
+// fails with the *errors.ChallengeError or *errors.BlockError it is, any
+// other HTML page with errors.ErrNotJSON, and a non-2xx status with an
+// *errors.StatusError holding the status and the start of the body.
+func GetJSON[T any](ctx context.Context, s *Scraper, url string) (T, error) {
+	var v T
+	req, err := s.newRequest(ctx, http.MethodGet, url, "", nil)
+	if err != nil {
+		return v, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.execute(req)
+	if err != nil {
+		return v, err
+	}
+	body, err := s.readAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return v, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if err := checkJSON(resp, body); err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return v, fmt.Errorf("failed to decode JSON from %s: %w", resp.Request.URL, err)
+	}
+	return v, nil
+}
+
+// checkJSON returns an error describing why resp, with the given body, is
+// not the JSON that was asked for, or nil if it may be.
+func checkJSON(resp *http.Response, body []byte) error {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	html := mediaType == "text/html" || mediaType == "application/xhtml+xml" ||
+		bytes.HasPrefix(bytes.TrimSpace(body), []byte("<"))
+	if html {
+		text := toUTF8(resp.Header.Get("Content-Type"), body)
+		if block := detectBlock(resp, text); block != nil {
+			return block
+		}
+		if detection := DetectChallenge(resp, text); detection.Challenge {
+			return &errors.ChallengeError{
+				Stage:      errors.StageDetect,
+				URL:        resp.Request.URL.String(),
+				StatusCode: resp.StatusCode,
+				RayID:      resp.Header.Get("Cf-Ray"),
+				Err:        fmt.Errorf("%w: %s", errors.ErrUnknownChallenge, detection),
+			}
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &errors.StatusError{
+			URL:        resp.Request.URL.String(),
+			StatusCode: resp.StatusCode,
+			Body:       bodySnippet(toUTF8(resp.Header.Get("Content-Type"), body)),
+		}
+	}
+	if html {
+		return fmt.Errorf("%w: %s served an HTML page", errors.ErrNotJSON, resp.Request.URL)
+	}
+	return nil
+}
+
+// maxSnippet bounds the body kept in an *errors.StatusError.
+const maxSnippet = 512
+
+// bodySnippet returns the start of body, at most maxSnippet bytes of it
+// with a rune cut in half dropped, and its whitespace collapsed.
+func bodySnippet(body []byte) string {
+	if len(body) > maxSnippet {
+		body = body[:maxSnippet]
+	}
+	return strings.Join(strings.Fields(strings.ToValidUTF8(string(body), "")), " ")
+}
@@ -0,0 +1,53 @@
+package cloudscraper
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
+)
+
+func TestGetJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/item":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id": 7, "name": "widget"}`)
+		case "/missing":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error": "no such item"}%s`, strings.Repeat(" ", 2*maxSnippet))
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>Sign in</body></html>`)
+		}
+	}))
+	defer srv.Close()
+	s := newTestScraper(t)
+
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	got, err := GetJSON[item](t.Context(), s, srv.URL+"/item")
+	if err != nil || got != (item{7, "widget"}) {
+		t.Errorf("GetJSON = %+v, %v", got, err)
+	}
+
+	_, err = GetJSON[item](t.Context(), s, srv.URL+"/missing")
+	var statusErr *errors.StatusError
+	if !stderrors.As(err, &statusErr) || !stderrors.Is(err, errors.ErrUnexpectedStatus) {
+		t.Fatalf("404 gave %v, want an *errors.StatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusNotFound || statusErr.Body != `{"error": "no such item"}` {
+		t.Errorf("StatusError = %d %q", statusErr.StatusCode, statusErr.Body)
+	}
+
+	if _, err = GetJSON[item](t.Context(), s, srv.URL+"/page"); !stderrors.Is(err, errors.ErrNotJSON) {
+		t.Errorf("HTML page gave %v, want ErrNotJSON", err)
+	}
+}
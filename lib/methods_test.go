@@ -0,0 +1,86 @@
+package cloudscraper
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Advik-B/cloudscraper/lib/cftest"
+)
+
+// echoHandler is an origin that answers with the request's method, media
+// type and body, and with a multipart body's field and file.
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Method", r.Method)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		content, _ := io.ReadAll(f)
+		fmt.Fprintf(w, "%s %s name=%s file=%s", r.Method, mediaType, r.FormValue("name"), content)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	fmt.Fprintf(w, "%s %s %s", r.Method, mediaType, body)
+})
+
+func TestMethodsThroughChallenge(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		send   func(s *Scraper, u string) (*http.Response, error)
+		want   string
+	}{
+		{"head", http.MethodHead, func(s *Scraper, u string) (*http.Response, error) { return s.Head(u) }, ""},
+		{"put", http.MethodPut, func(s *Scraper, u string) (*http.Response, error) {
+			return s.Put(u, "text/plain", strings.NewReader("new content"))
+		}, "PUT text/plain new content"},
+		{"patch", http.MethodPatch, func(s *Scraper, u string) (*http.Response, error) {
+			return s.Patch(u, "application/merge-patch+json", strings.NewReader(`{"a":1}`))
+		}, `PATCH application/merge-patch+json {"a":1}`},
+		{"delete", http.MethodDelete, func(s *Scraper, u string) (*http.Response, error) { return s.Delete(u) }, "DELETE  "},
+		{"post form", http.MethodPost, func(s *Scraper, u string) (*http.Response, error) {
+			return s.PostForm(u, url.Values{"q": {"a b"}})
+		}, "POST application/x-www-form-urlencoded q=a+b"},
+		{"post json", http.MethodPost, func(s *Scraper, u string) (*http.Response, error) {
+			return s.PostJSON(u, map[string]int{"n": 7})
+		}, `POST application/json {"n":7}`},
+		{"multipart", http.MethodPost, func(s *Scraper, u string) (*http.Response, error) {
+			m := NewMultipart().Field("name", "report").File("file", "report.txt", "text/plain", strings.NewReader("file content"))
+			return s.PostMultipart(u, m)
+		}, "POST multipart/form-data name=report file=file content"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv := cftest.NewServer(cftest.Config{Challenge: cftest.Managed, Handler: echoHandler})
+			defer srv.Close()
+			s := newTestScraper(t)
+
+			resp, err := tt.send(s, srv.URL+"/api")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Method") != tt.method || string(body) != tt.want {
+				t.Errorf("got %d %s %q, want 200 %s %q", resp.StatusCode, resp.Header.Get("X-Method"), body, tt.method, tt.want)
+			}
+			if stats := srv.Stats(); stats.Solved != 1 || stats.Failed != 0 {
+				t.Errorf("solved %d, failed %d; want 1 and 0", stats.Solved, stats.Failed)
+			}
+		})
+	}
+}
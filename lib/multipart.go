@@ -0,0 +1,106 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Multipart builds a multipart/form-data body, such as a file upload. The
+// body is assembled in memory, so the request can be replayed after a
+// challenge or a 403 refresh. The first error met is kept and reported by
+// PostMultipart.
+type Multipart struct {
+	buf    bytes.Buffer
+	w      *multipart.Writer
+	closed bool
+	err    error
+}
+
+// quoteEscaper escapes quoted strings in a Content-Disposition header, as
+// mime/multipart does.
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// NewMultipart returns an empty multipart/form-data body.
+func NewMultipart() *Multipart {
+	m := &Multipart{}
+	m.w = multipart.NewWriter(&m.buf)
+	return m
+}
+
+// Field adds a form field.
+func (m *Multipart) Field(name, value string) *Multipart {
+	if m.ok() {
+		m.err = m.w.WriteField(name, value)
+	}
+	return m
+}
+
+// File adds the contents of r as a file named filename under the form field
+// name. contentType defaults to application/octet-stream.
+func (m *Multipart) File(name, filename, contentType string, r io.Reader) *Multipart {
+	if !m.ok() {
+		return m
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(name), quoteEscaper.Replace(filename)))
+	h.Set("Content-Type", contentType)
+	part, err := m.w.CreatePart(h)
+	if err == nil {
+		_, err = io.Copy(part, r)
+	}
+	m.err = err
+	return m
+}
+
+// FilePath adds the file at path under the form field name, with its base
+// name as the file name.
+func (m *Multipart) FilePath(name, path, contentType string) *Multipart {
+	if !m.ok() {
+		return m
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		m.err = err
+		return m
+	}
+	defer f.Close()
+	return m.File(name, filepath.Base(path), contentType, f)
+}
+
+// ok reports whether parts can still be added.
+func (m *Multipart) ok() bool {
+	if m.err == nil && m.closed {
+		m.err = errors.New("multipart body already posted")
+	}
+	return m.err == nil
+}
+
+// PostMultipart performs a POST request with m as the body.
+func (s *Scraper) PostMultipart(url string, m *Multipart) (*http.Response, error) {
+	return s.PostMultipartContext(context.Background(), url, m)
+}
+
+// PostMultipartContext performs a POST request bound to ctx with m as the
+// body. m is complete once posted and cannot be added to.
+func (s *Scraper) PostMultipartContext(ctx context.Context, url string, m *Multipart) (*http.Response, error) {
+	if m.err == nil && !m.closed {
+		m.err = m.w.Close()
+		m.closed = true
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return s.send(ctx, http.MethodPost, url, m.w.FormDataContentType(), bytes.NewReader(m.buf.Bytes()))
+}
@@ -7,6 +7,7 @@ import (
 	useragent "github.com/Advik-B/cloudscraper/lib/user_agent"
 
 	"github.com/Advik-B/cloudscraper/lib/captcha"
+	"github.com/Advik-B/cloudscraper/lib/clearance"
 	"github.com/Advik-B/cloudscraper/lib/js"
 	"github.com/Advik-B/cloudscraper/lib/proxy"
 	"github.com/Advik-B/cloudscraper/lib/stealth"
@@ -15,22 +16,34 @@ import (
 // Options holds all configuration for the scraper.
 type Options struct {
 	MaxRetries             int
+	RetryPolicy            RetryPolicy
+	MaxRedirects           int // 0 disables following redirects
 	Delay                  time.Duration
 	AutoRefreshOn403       bool
 	AutoRefreshSession     bool
 	SessionRefreshInterval time.Duration
 	Max403Retries          int
+	ChallengeTimeout       time.Duration // 0 disables the per-solve limit
+	ChallengeWaitTimeout   time.Duration // 0 waits for a concurrent solve indefinitely
+	MaxChallengeStages     int           // challenges one request may run into; 0 lifts the cap
+	MaxChallengeRepeats    int           // solves of the same challenge before escalating; 0 never escalates
+	ClearanceRefreshMargin time.Duration // how long before expiry a clearance is renewed; 0 disables renewal
+	Streaming              bool          // hand bodies over as they arrive instead of buffering them
+	MaxBodyBytes           int64         // largest body read into memory; 0 lifts the limit
 	Browser                useragent.Config
 	RotateTlsCiphers       bool
 	CaptchaSolver          captcha.Solver
+	ClearanceStore         clearance.Store
+	ChallengeRegistry      *ChallengeRegistry
 	Proxies                []string
 	ProxyOptions           struct {
 		Strategy proxy.Strategy
 		BanTime  time.Duration
 	}
-	Stealth   stealth.Options
-	JSRuntime js.Runtime // "otto", "node", "deno", "bun"
-	Logger    *log.Logger
+	Stealth    stealth.Options
+	CookieFile string     // loaded by New and saved whenever the jar changes
+	JSRuntime  js.Runtime // "otto", "node", "deno", "bun"
+	Logger     *log.Logger
 }
 
 // ScraperOption configures a Scraper.
@@ -50,6 +63,46 @@ func WithCaptchaSolver(solver captcha.Solver) ScraperOption {
 	}
 }
 
+// WithChallengeWaitTimeout bounds how long a request waits for a concurrent
+// request's solve of the same host's challenge. A wait that runs over fails
+// with errors.ErrChallengeTimeout. The default is two minutes.
+func WithChallengeWaitTimeout(d time.Duration) ScraperOption {
+	return func(o *Options) {
+		o.ChallengeWaitTimeout = d
+	}
+}
+
+// WithChallengeHandler registers h under name with the given priority,
+// alongside the built-in handlers. Registering under a built-in name such as
+// ChallengeV1 replaces that handler.
+func WithChallengeHandler(name string, priority int, h ChallengeHandler) ScraperOption {
+	return func(o *Options) {
+		if o.ChallengeRegistry == nil {
+			o.ChallengeRegistry = NewChallengeRegistry()
+		}
+		o.ChallengeRegistry.Register(name, priority, h)
+	}
+}
+
+// WithChallengeRegistry replaces the scraper's challenge handlers with those
+// in r.
+func WithChallengeRegistry(r *ChallengeRegistry) ScraperOption {
+	return func(o *Options) {
+		o.ChallengeRegistry = r
+	}
+}
+
+// WithClearanceStore shares cf_clearance cookies through store. Before
+// solving a challenge the scraper looks for a clearance issued to the same
+// domain, User-Agent and proxy, and after solving one it publishes the
+// clearance it was given. Use clearance.NewFileStore to share clearances
+// between processes.
+func WithClearanceStore(store clearance.Store) ScraperOption {
+	return func(o *Options) {
+		o.ClearanceStore = store
+	}
+}
+
 // WithProxies configures the proxy manager.
 func WithProxies(proxyURLs []string, strategy proxy.Strategy, banTime time.Duration) ScraperOption {
 	return func(o *Options) {
@@ -99,3 +152,98 @@ func WithLogger(logger *log.Logger) ScraperOption {
 		o.Logger = logger
 	}
 }
+
+// WithMaxRedirects caps how many redirects a single request follows before
+// failing with errors.ErrTooManyRedirects. Zero returns 3xx responses to the
+// caller unfollowed. The default is 10.
+func WithMaxRedirects(n int) ScraperOption {
+	return func(o *Options) {
+		o.MaxRedirects = n
+	}
+}
+
+// WithMaxRetries sets how many times a failed request is retried. Zero
+// disables retries.
+func WithMaxRetries(n int) ScraperOption {
+	return func(o *Options) {
+		o.MaxRetries = n
+	}
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy with a custom policy that
+// decides, per attempt, whether to retry, how long to wait and whether to
+// rotate the proxy.
+func WithRetryPolicy(policy RetryPolicy) ScraperOption {
+	return func(o *Options) {
+		o.RetryPolicy = policy
+	}
+}
+
+// WithChallengeTimeout bounds the time spent solving a single challenge.
+// A solve that runs over fails with errors.ErrChallengeTimeout and is
+// retried by the default policy. The default is two minutes.
+func WithChallengeTimeout(d time.Duration) ScraperOption {
+	return func(o *Options) {
+		o.ChallengeTimeout = d
+	}
+}
+
+// WithChallengeLimits bounds the challenges a single request may go through.
+// maxStages caps them in total, so that a site answering every solve with
+// another challenge fails with errors.ErrChallengeLoop instead of recursing
+// forever. maxRepeats is how often the same challenge on the same URL is
+// solved before the scraper escalates: to the captcha solver if the page
+// carries a captcha widget and one is configured, then to a fresh browser
+// identity, and finally to failing. The defaults are 8 and 2.
+func WithChallengeLimits(maxStages, maxRepeats int) ScraperOption {
+	return func(o *Options) {
+		o.MaxChallengeStages = maxStages
+		o.MaxChallengeRepeats = maxRepeats
+	}
+}
+
+// WithClearanceRefresh sets how long before its cf_clearance cookie expires
+// a domain's clearance is renewed. The first request to the domain within
+// that margin drops the cookie, so that it is challenged and solved again
+// straight away rather than failing halfway through work that outlives the
+// cookie. The margin is capped at a quarter of the cookie's lifetime. Zero
+// disables renewal. The default is two minutes.
+func WithClearanceRefresh(margin time.Duration) ScraperOption {
+	return func(o *Options) {
+		o.ClearanceRefreshMargin = margin
+	}
+}
+
+// WithStreaming hands response bodies to the caller as they arrive from the
+// network instead of reading them into memory first. Only responses that may
+// be a challenge or block page, those with a status of 400 or above or a
+// Cf-Mitigated header, are read ahead, and only as far as needed to detect
+// one; the body the caller gets replays what was read and continues from
+// the network. Streamed requests are not subject to the client's 30-second
+// timeout, which covers reading the body; bound them with a context instead.
+// Use it for large downloads.
+func WithStreaming(enabled bool) ScraperOption {
+	return func(o *Options) {
+		o.Streaming = enabled
+	}
+}
+
+// WithMaxBodyBytes caps the response bodies the scraper reads into memory:
+// every body when not streaming, and challenge pages when streaming. A
+// larger body fails with errors.ErrBodyTooLarge. Zero, the default, lifts
+// the limit.
+func WithMaxBodyBytes(n int64) ScraperOption {
+	return func(o *Options) {
+		o.MaxBodyBytes = n
+	}
+}
+
+// WithCookieFile persists the cookie jar to path. Cookies saved there are
+// loaded by New, and the file is rewritten whenever the jar changes, so
+// clearance cookies survive restarts. The format follows the extension, as
+// with Scraper.SaveCookies.
+func WithCookieFile(path string) ScraperOption {
+	return func(o *Options) {
+		o.CookieFile = path
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"net/url"
 	"sync"
 	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
 )
 
 // Strategy defines the proxy rotation strategy.
@@ -70,7 +72,7 @@ func (m *Manager) GetProxy() (*url.URL, error) {
 
 	available := m.getAvailableProxies()
 	if len(available) == 0 {
-		return nil, fmt.Errorf("all proxies are currently banned")
+		return nil, errors.ErrAllProxiesBanned
 	}
 
 	var chosen *url.URL
@@ -0,0 +1,214 @@
+package cloudscraper
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
+	"golang.org/x/net/publicsuffix"
+)
+
+// RedirectChain returns the redirect responses that led to resp, oldest first.
+// It is empty when resp was not reached through a redirect. The bodies of the
+// returned responses have already been consumed and closed.
+func RedirectChain(resp *http.Response) []*http.Response {
+	var chain []*http.Response
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		chain = append(chain, req.Response)
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// isRedirect reports whether resp is a redirect the scraper follows.
+func isRedirect(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return resp.Header.Get("Location") != ""
+	}
+	return false
+}
+
+// followRedirect issues the request that resp redirects req to. The new
+// request records resp in its Response field, so the chain stays walkable
+// from the final response via RedirectChain.
+func (s *Scraper) followRedirect(req *http.Request, resp *http.Response) (*http.Response, error) {
+	if s.opts.MaxRedirects == 0 {
+		return resp, nil
+	}
+
+	loc, err := resp.Location()
+	if err != nil {
+		return resp, nil
+	}
+
+	hops := len(RedirectChain(resp)) + 1
+	if hops > s.opts.MaxRedirects {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: stopped after %d redirects at %s", errors.ErrTooManyRedirects, s.opts.MaxRedirects, loc)
+	}
+
+	method := req.Method
+	preserveBody := false
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+		// RFC 9110 §15.4: user agents rewrite these to GET, keeping HEAD as is.
+		if method != http.MethodHead {
+			method = http.MethodGet
+		}
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		// 307 and 308 must repeat the request unchanged, body included.
+		preserveBody = true
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, nil
+		}
+	}
+
+	next, err := http.NewRequestWithContext(req.Context(), method, loc.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	next.Header = redirectHeaders(req, loc, preserveBody)
+	if preserveBody && req.GetBody != nil {
+		next.Body, err = req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to rewind request body for redirect: %w", err)
+		}
+		next.GetBody = req.GetBody
+		next.ContentLength = req.ContentLength
+	}
+
+	resp.Body.Close()
+	next.Response = resp
+
+	if next.Header.Get("Sec-Fetch-Site") != "" {
+		next.Header.Set("Sec-Fetch-Site", fetchSite(next))
+	}
+
+	s.logger.Printf("Following %d redirect (%d/%d) to %s\n", resp.StatusCode, hops, s.opts.MaxRedirects, loc)
+	return s.do(next)
+}
+
+// redirectHeaders builds the headers for the request that follows req to loc.
+func redirectHeaders(req *http.Request, loc *url.URL, preserveBody bool) http.Header {
+	h := req.Header.Clone()
+
+	if !preserveBody {
+		for key := range h {
+			if strings.HasPrefix(http.CanonicalHeaderKey(key), "Content-") {
+				h.Del(key)
+			}
+		}
+		h.Del("Origin")
+	}
+
+	// Like net/http, don't leak credentials to a host outside the original's domain.
+	if !isDomainOrSubdomain(loc.Hostname(), req.URL.Hostname()) {
+		for _, key := range []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2"} {
+			h.Del(key)
+		}
+	}
+
+	// A redirect keeps the navigation's referrer, not the redirecting URL.
+	h.Del("Referer")
+	if ref := refererFor(initialRequest(req).Header.Get("Referer"), loc); ref != "" {
+		h.Set("Referer", ref)
+	}
+	return h
+}
+
+// initialRequest returns the request that started req's redirect chain.
+func initialRequest(req *http.Request) *http.Request {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req
+}
+
+// refererFor returns the Referer a browser sends to 'to' for a navigation
+// whose referrer is ref, under the default strict-origin-when-cross-origin
+// policy: ref in full to its own origin, only its origin to another, and
+// nothing on an HTTPS to HTTP downgrade.
+func refererFor(ref string, to *url.URL) string {
+	from, err := url.Parse(ref)
+	if ref == "" || err != nil || from.Host == "" {
+		return ""
+	}
+	if from.Scheme == "https" && to.Scheme != "https" {
+		return ""
+	}
+	if !sameOrigin(from, to) {
+		return (&url.URL{Scheme: from.Scheme, Host: from.Host, Path: "/"}).String()
+	}
+	trimmed := *from
+	trimmed.User = nil
+	trimmed.Fragment = ""
+	trimmed.RawFragment = ""
+	return trimmed.String()
+}
+
+// fetchSite computes Sec-Fetch-Site for req, which may be a redirected
+// request, following the Fetch Metadata rules: "none" sticks for
+// user-initiated navigations; otherwise the value is the weakest relation
+// between the initiator and every URL in the chain. Stealth mode presets
+// "none" on every request, so a chain that started with a Referer is taken
+// to be a navigation from that page instead.
+func fetchSite(req *http.Request) string {
+	var urls []*url.URL
+	first := req
+	for r := req; r != nil; {
+		urls = append(urls, r.URL)
+		first = r
+		if r.Response == nil {
+			break
+		}
+		r = r.Response.Request
+	}
+
+	if first.Header.Get("Referer") == "" && first.Header.Get("Sec-Fetch-Site") == "none" {
+		return "none"
+	}
+
+	initiator := first.URL
+	if ref, err := url.Parse(first.Header.Get("Referer")); err == nil && ref.Host != "" {
+		initiator = ref
+	}
+
+	site := "same-origin"
+	for _, u := range urls {
+		if sameOrigin(initiator, u) {
+			continue
+		}
+		if !sameSite(initiator, u) {
+			return "cross-site"
+		}
+		site = "same-site"
+	}
+	return site
+}
+
+func sameOrigin(a, b *url.URL) bool {
+	return a.Scheme == b.Scheme && strings.EqualFold(a.Host, b.Host)
+}
+
+func sameSite(a, b *url.URL) bool {
+	siteA, errA := publicsuffix.EffectiveTLDPlusOne(a.Hostname())
+	siteB, errB := publicsuffix.EffectiveTLDPlusOne(b.Hostname())
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a.Hostname(), b.Hostname())
+	}
+	return strings.EqualFold(siteA, siteB)
+}
+
+// isDomainOrSubdomain reports whether sub is parent or a subdomain of it.
+func isDomainOrSubdomain(sub, parent string) bool {
+	if strings.EqualFold(sub, parent) {
+		return true
+	}
+	return strings.HasSuffix(strings.ToLower(sub), "."+strings.ToLower(parent))
+}
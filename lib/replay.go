@@ -0,0 +1,122 @@
+package cloudscraper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	useragent "github.com/Advik-B/cloudscraper/lib/user_agent"
+)
+
+// makeReplayable ensures req.GetBody is set so the request can be re-sent
+// after a challenge solve or a 403 session refresh. Bodies created from a
+// bytes or strings reader already have GetBody; anything else is buffered.
+func makeReplayable(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to buffer request body: %w", err)
+	}
+
+	req.ContentLength = int64(len(buf))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return nil
+}
+
+// cloneForReplay returns a copy of req with a fresh body obtained from GetBody.
+func cloneForReplay(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody == nil {
+		return clone, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewind request body: %w", err)
+	}
+	clone.Body = body
+	return clone, nil
+}
+
+// cloneForIdentity returns a copy of req, as cloneForReplay does, without
+// the headers agent filled in, so that do applies the current identity's
+// instead. Headers the caller set to other values are kept.
+func cloneForIdentity(req *http.Request, agent *useragent.Agent) (*http.Request, error) {
+	clone, err := cloneForReplay(req)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range agent.Headers {
+		if slices.Equal(clone.Header[key], values) {
+			delete(clone.Header, key)
+		}
+	}
+	return clone, nil
+}
+
+// replayAfterChallenge re-issues the caller's original request once a
+// challenge has been solved. When the solve already landed on an equivalent
+// bodiless GET of the same URL, that response is returned as is.
+func (s *Scraper) replayAfterChallenge(original *http.Request, solved *http.Response) (*http.Response, error) {
+	if isEquivalentFetch(original, solved.Request) {
+		return solved, nil
+	}
+	solved.Body.Close()
+
+	s.logger.Printf("Challenge solved, replaying original %s %s\n", original.Method, original.URL)
+	replay, err := cloneForReplay(original)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(replay)
+}
+
+type headSolveKey struct{}
+
+// solveForHead deals with a challenge met by a HEAD request, whose response
+// has no page to solve: the page is fetched with a GET of the same URL,
+// which solves it like any other, and the HEAD is then replayed with the
+// clearance. ok is false if req is itself such a replay, still challenged.
+func (s *Scraper) solveForHead(ctx context.Context, req *http.Request, resp *http.Response) (_ *http.Response, ok bool, err error) {
+	if ctx.Value(headSolveKey{}) != nil {
+		return nil, false, nil
+	}
+	resp.Body.Close()
+	ctx = context.WithValue(ctx, headSolveKey{}, true)
+
+	s.logger.Printf("HEAD %s was challenged, fetching the page to solve it\n", req.URL)
+	get := req.Clone(ctx)
+	get.Method = http.MethodGet
+	page, err := s.do(get)
+	if err != nil {
+		return nil, true, err
+	}
+	page.Body.Close()
+
+	replay, err := cloneForReplay(req)
+	if err != nil {
+		return nil, true, err
+	}
+	resp, err = s.do(replay.WithContext(ctx))
+	return resp, true, err
+}
+
+func isEquivalentFetch(original, final *http.Request) bool {
+	if final == nil || original.Method != http.MethodGet || final.Method != http.MethodGet {
+		return false
+	}
+	if original.Header.Get("Range") != "" {
+		// The solve fetched the whole resource, not the range asked for.
+		return false
+	}
+	return original.URL.String() == final.URL.String()
+}
@@ -0,0 +1,69 @@
+package cloudscraper
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Advik-B/cloudscraper/lib/cftest"
+)
+
+// TestReplayAfterChallenge sends requests whose body can only be read once,
+// and checks the origin gets their method, headers and body in full after a
+// challenge, a 403 or both.
+func TestReplayAfterChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       cftest.Config
+		forbidden int
+		solved    int
+	}{
+		{"challenge", cftest.Config{Challenge: cftest.Managed}, 0, 1},
+		{"403", cftest.Config{Challenge: cftest.None, Forbidden: 2}, 2, 0},
+		{"403 then challenge", cftest.Config{Challenge: cftest.Managed, Forbidden: 1}, 1, 1},
+	}
+	for _, tt := range tests {
+		for _, method := range []string{http.MethodPost, http.MethodPut} {
+			t.Run(tt.name+" "+method, func(t *testing.T) {
+				// The origin also sees the GETs of warm-ups and of the redirect
+				// after a solve; the request itself must arrive exactly once.
+				var sent atomic.Int32
+				tt.cfg.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Method == method {
+						sent.Add(1)
+					}
+					w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+					echoHandler(w, r)
+				})
+				srv := cftest.NewServer(tt.cfg)
+				defer srv.Close()
+				s := newTestScraper(t)
+
+				content := strings.Repeat("payload ", 1<<10)
+				req, _ := http.NewRequest(method, srv.URL+"/api", io.NopCloser(strings.NewReader(content)))
+				req.Header.Set("Content-Type", "text/plain")
+				req.Header.Set("X-Request-Id", "42")
+				resp, err := s.Send(req)
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				want := method + " text/plain " + content
+				if resp.StatusCode != http.StatusOK || string(body) != want || resp.Header.Get("X-Request-Id") != "42" {
+					t.Errorf("origin got %d %.40q... (X-Request-Id %q), want 200 %.40q... (42)",
+						resp.StatusCode, body, resp.Header.Get("X-Request-Id"), want)
+				}
+				if n := sent.Load(); n != 1 {
+					t.Errorf("origin got the %s %d times, want once", method, n)
+				}
+				if stats := srv.Stats(); stats.Forbidden != tt.forbidden || stats.Solved != tt.solved {
+					t.Errorf("forbidden %d and solved %d, want %d and %d", stats.Forbidden, stats.Solved, tt.forbidden, tt.solved)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package cloudscraper
+
+import (
+	"context"
+	stderrors "errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Advik-B/cloudscraper/lib/errors"
+	"github.com/Advik-B/cloudscraper/lib/transport"
+)
+
+// Attempt describes the outcome of one try of a request.
+type Attempt struct {
+	// Number is the 1-based attempt number.
+	Number int
+	// Request is the request that was sent.
+	Request *http.Request
+	// Response is the final response, or nil if the attempt failed with Err.
+	Response *http.Response
+	// Err is the error the attempt failed with, if any.
+	Err error
+	// Proxy is the proxy the attempt was routed through, or nil.
+	Proxy *url.URL
+}
+
+// RetryDecision is a RetryPolicy's verdict on a finished attempt.
+type RetryDecision struct {
+	// Retry reports whether another attempt should be made.
+	Retry bool
+	// Delay is how long to wait before the next attempt.
+	Delay time.Duration
+	// RotateProxy reports the attempt's proxy as failed to the proxy manager,
+	// so the next attempt is routed through a different one. Transport errors
+	// are always reported, so this is only needed for failures the proxy
+	// manager cannot see, such as a 502 from the proxy itself.
+	RotateProxy bool
+	// RefreshSession rotates the browser identity and TLS profile before the
+	// next attempt.
+	RefreshSession bool
+}
+
+// RetryPolicy decides, after each attempt, whether a request is retried.
+// The number of attempts is capped separately by Options.MaxRetries.
+type RetryPolicy interface {
+	Decide(a Attempt) RetryDecision
+}
+
+// DefaultRetryPolicy retries transport errors, 429 and 5xx responses of
+// idempotent requests with exponential backoff and full jitter. A
+// Retry-After header overrides the backoff. A 403 that outlasted the
+// scraper's session refreshes (Max403Retries) is not retried.
+//
+// Challenges are retried for any request, since the origin never saw it,
+// but only when another attempt can go differently: a solve that timed out
+// (errors.ErrChallengeTimeout) or broke off on a transport error, and a
+// challenge that keeps coming back (errors.ErrChallengeLoop), which is
+// retried with a refreshed session. A refused answer, a missing captcha
+// solver or a page the solver cannot read would fail the same way again.
+//
+// Cloudflare block pages are retried only under a new identity: a rate limit
+// (1015) or IP ban (1006) through another proxy, a browser signature ban
+// (1010) with a refreshed session, and a firewall block (1020) through
+// another proxy with a refreshed session. Without proxies, 1006, 1015 and
+// 1020 are not retried.
+type DefaultRetryPolicy struct {
+	// BaseDelay is the backoff before the first retry. Defaults to 500ms.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff and the Retry-After the policy is willing to
+	// wait for. Defaults to 30s.
+	MaxDelay time.Duration
+}
+
+// Decide implements RetryPolicy.
+func (p DefaultRetryPolicy) Decide(a Attempt) RetryDecision {
+	base, maxDelay := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+
+	var block *errors.BlockError
+	if stderrors.As(a.Err, &block) {
+		return decideBlock(a, block, base, maxDelay)
+	}
+
+	if a.Err != nil {
+		if !retryableError(a.Err, a.Request) {
+			return RetryDecision{}
+		}
+		// Transport errors were already reported against the proxy by the scraper.
+		decision := RetryDecision{Retry: true, Delay: backoff(a.Number, base, maxDelay)}
+		if stderrors.Is(a.Err, errors.ErrChallengeLoop) {
+			// The loop already used up the chain's own fresh identity.
+			decision.RefreshSession = true
+		}
+		return decision
+	}
+
+	resp := a.Response
+	if !retryableStatus(resp.StatusCode) || !isIdempotent(a.Request) {
+		return RetryDecision{}
+	}
+
+	delay := backoff(a.Number, base, maxDelay)
+	if after, ok := retryAfter(resp); ok {
+		if after > maxDelay {
+			return RetryDecision{}
+		}
+		delay = after
+	}
+
+	// 502 and 504 usually come from the proxy rather than the origin.
+	rotate := a.Proxy != nil && (resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusGatewayTimeout)
+	return RetryDecision{Retry: true, Delay: delay, RotateProxy: rotate}
+}
+
+// decideBlock retries a Cloudflare block page under a different identity.
+func decideBlock(a Attempt, block *errors.BlockError, base, maxDelay time.Duration) RetryDecision {
+	decision := RetryDecision{Retry: true, Delay: backoff(a.Number, base, maxDelay)}
+	switch block.Code {
+	case 1015:
+		if block.RetryAfter > maxDelay {
+			return RetryDecision{}
+		}
+		if block.RetryAfter > 0 {
+			decision.Delay = block.RetryAfter
+		}
+		decision.RotateProxy = true
+	case 1006:
+		decision.RotateProxy = true
+	case 1010:
+		decision.RefreshSession = true
+		return decision
+	case 1020:
+		decision.RotateProxy = true
+		decision.RefreshSession = true
+	default:
+		return RetryDecision{}
+	}
+	if a.Proxy == nil {
+		// The next attempt would come from the same address.
+		return RetryDecision{}
+	}
+	return decision
+}
+
+func retryableError(err error, req *http.Request) bool {
+	switch {
+	case stderrors.Is(err, context.Canceled),
+		stderrors.Is(err, context.DeadlineExceeded),
+		stderrors.Is(err, errors.ErrChallengeRejected),
+		stderrors.Is(err, errors.ErrNoCaptchaSolver),
+		stderrors.Is(err, errors.ErrUnknownChallenge),
+		stderrors.Is(err, errors.ErrBodyTooLarge),
+		stderrors.Is(err, errors.ErrAllProxiesBanned),
+		stderrors.Is(err, errors.ErrTooManyRedirects),
+		stderrors.Is(err, errors.ErrMaxRetriesExceeded):
+		// A RetryError from within, such as handle403's, has already had its
+		// own round of attempts.
+		return false
+	case stderrors.Is(err, errors.ErrChallengeTimeout), stderrors.Is(err, errors.ErrChallengeLoop):
+		// The origin never saw a request that was stopped by a challenge.
+		return true
+	case stderrors.Is(err, errors.ErrChallenge):
+		// Anything else about the challenge itself fails the same way again;
+		// only a transport failure along the way is worth another attempt.
+		var ce *errors.ChallengeError
+		if stderrors.As(err, &ce) && (ce.Stage == errors.StageDetect || ce.Stage == errors.StageExtract) {
+			return false
+		}
+		var transportErr *url.Error
+		return stderrors.As(err, &transportErr)
+	}
+	return isIdempotent(req)
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported, http.StatusNetworkAuthenticationRequired:
+		return false
+	}
+	return code >= 500 && code <= 599
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// backoff returns a full-jitter exponential delay for the given attempt number.
+func backoff(attempt int, base, maxDelay time.Duration) time.Duration {
+	ceiling := float64(base) * math.Pow(2, float64(attempt-1))
+	if ceiling > float64(maxDelay) {
+		ceiling = float64(maxDelay)
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// execute runs req through the retry policy, making at most
+// Options.MaxRetries additional attempts. Each attempt pins its proxy in the
+// request context, so redirects, challenge submissions and replays within
+// the attempt share one egress. When every attempt fails with an error, it
+// returns an *errors.RetryError; when every attempt gets a retryable status,
+// it returns the last response.
+func (s *Scraper) execute(req *http.Request) (*http.Response, error) {
+	if err := makeReplayable(req); err != nil {
+		return nil, err
+	}
+
+	policy := s.opts.RetryPolicy
+	if policy == nil {
+		policy = DefaultRetryPolicy{}
+	}
+	maxAttempts := s.opts.MaxRetries + 1
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var last Attempt
+	for n := 1; n <= maxAttempts; n++ {
+		// Every attempt sends a copy, so that req keeps no headers of the
+		// identity an attempt went out under.
+		try, err := cloneForReplay(req)
+		if err != nil {
+			return nil, err
+		}
+
+		// A proxy already in the request's context is pinned, e.g. by Download.
+		proxyURL := transport.ProxyFromContext(try.Context())
+		if proxyURL == nil && s.ProxyManager != nil {
+			var err error
+			if proxyURL, err = s.ProxyManager.GetProxy(); err != nil {
+				return nil, err
+			}
+			if proxyURL != nil {
+				try = try.WithContext(transport.WithProxy(try.Context(), proxyURL))
+			}
+		}
+
+		resp, err := s.do(try)
+		last = Attempt{Number: n, Request: try, Response: resp, Err: err, Proxy: proxyURL}
+
+		decision := policy.Decide(last)
+		if !decision.Retry {
+			return resp, err
+		}
+		if decision.RotateProxy && proxyURL != nil {
+			s.reportProxyFailure(proxyURL)
+		}
+		if n == maxAttempts {
+			break
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if decision.RefreshSession {
+			_, gen := s.identity()
+			if err := s.refreshSession(req.Context(), req.URL, gen); err != nil {
+				s.logger.Printf("Warning: session refresh before retry failed: %v\n", err)
+			}
+		}
+
+		s.logger.Printf("Attempt %d/%d failed, retrying in %v\n", n, maxAttempts, decision.Delay)
+		if err := sleepContext(req.Context(), decision.Delay); err != nil {
+			return nil, err
+		}
+	}
+
+	if last.Response != nil {
+		// Out of attempts on a status such as 503 or 429: the caller gets the
+		// last response as it is, with its body and headers like Retry-After.
+		return last.Response, nil
+	}
+	return nil, &errors.RetryError{Attempts: last.Number, Err: last.Err}
+}
@@ -0,0 +1,89 @@
+package cloudscraper
+
+import (
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Advik-B/cloudscraper/lib/cftest"
+	"github.com/Advik-B/cloudscraper/lib/errors"
+)
+
+func TestDefaultRetryPolicyChallenges(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/", nil)
+	transportErr := &url.Error{Op: "Post", URL: "https://example.com/", Err: io.ErrUnexpectedEOF}
+	tests := []struct {
+		name    string
+		err     error
+		retry   bool
+		refresh bool
+	}{
+		{"timeout", &errors.ChallengeError{Stage: errors.StageExecute, Err: errors.ErrChallengeTimeout}, true, false},
+		{"loop", &errors.ChallengeError{Stage: errors.StageVerify, Err: fmt.Errorf("%w: v1 challenge seen 2 times", errors.ErrChallengeLoop)}, true, true},
+		{"submit transport error", &errors.ChallengeError{Stage: errors.StageSubmit, Err: transportErr}, true, false},
+		{"rejected", &errors.ChallengeError{Stage: errors.StageVerify, Err: errors.ErrChallengeRejected}, false, false},
+		{"no captcha solver", &errors.ChallengeError{Stage: errors.StageExecute, Err: errors.ErrNoCaptchaSolver}, false, false},
+		{"extract", &errors.ChallengeError{Stage: errors.StageExtract, Err: fmt.Errorf("could not find pass")}, false, false},
+		{"extract transport error", &errors.ChallengeError{Stage: errors.StageExtract, Err: transportErr}, false, false},
+		{"detect", &errors.ChallengeError{Stage: errors.StageDetect, Err: errors.ErrUnknownChallenge}, false, false},
+		{"no clearance", &errors.ChallengeError{Stage: errors.StageVerify, Err: errors.ErrNoClearance}, false, false},
+		{"transport error, POST", transportErr, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := DefaultRetryPolicy{}.Decide(Attempt{Number: 1, Request: req, Err: tt.err})
+			if d.Retry != tt.retry || d.RefreshSession != tt.refresh {
+				t.Errorf("Decide(%v) = retry %v, refresh %v; want %v, %v", tt.err, d.Retry, d.RefreshSession, tt.retry, tt.refresh)
+			}
+		})
+	}
+}
+
+func TestRetriesReturnLastResponse(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "down %d", n)
+	}))
+	defer srv.Close()
+	s := newTestScraper(t, WithMaxRetries(2))
+
+	resp, err := s.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || string(body) != "down 3" {
+		t.Errorf("got %d %q, want the third attempt's 503", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Retry-After") != "0" {
+		t.Error("last response lost its Retry-After header")
+	}
+}
+
+func TestForbiddenIsNotRetriedAfterRefreshes(t *testing.T) {
+	srv := cftest.NewServer(cftest.Config{Challenge: cftest.None, Forbidden: 1000})
+	defer srv.Close()
+	s := newTestScraper(t)
+
+	_, err := s.Get(srv.URL + "/page")
+	if !stderrors.Is(err, errors.ErrMaxRetriesExceeded) {
+		t.Fatalf("got error %v, want ErrMaxRetriesExceeded", err)
+	}
+	if strings.Count(err.Error(), errors.ErrMaxRetriesExceeded.Error()) != 1 {
+		t.Errorf("retry error nested in another: %v", err)
+	}
+	// The first 403, then one refused warm-up per session refresh.
+	if got, want := srv.Stats().Forbidden, s.opts.Max403Retries+1; got != want {
+		t.Errorf("server served %d 403s, want %d", got, want)
+	}
+}
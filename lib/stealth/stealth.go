@@ -1,8 +1,10 @@
 package stealth
 
 import (
+	"context"
 	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -16,9 +18,11 @@ type Options struct {
 	BrowserQuirks    bool
 }
 
-// Mode handles applying stealth techniques.
+// Mode handles applying stealth techniques. It is safe for concurrent use.
 type Mode struct {
-	opts            Options
+	opts Options
+
+	mu              sync.Mutex
 	requestCount    int
 	lastRequestTime time.Time
 }
@@ -41,12 +45,16 @@ func New(opts Options) *Mode {
 }
 
 // Apply applies all configured stealth techniques to a request.
-func (s *Mode) Apply(req *http.Request, browser string) {
+// The human-like delay honours the request's context; if it is cancelled
+// while waiting, Apply returns the context's error and leaves req untouched.
+func (s *Mode) Apply(req *http.Request, browser string) error {
 	if !s.opts.Enabled {
-		return
+		return nil
 	}
 
-	s.applyDelay()
+	if err := s.applyDelay(req.Context()); err != nil {
+		return err
+	}
 
 	if s.opts.RandomizeHeaders {
 		s.randomizeHeaders(req.Header)
@@ -56,18 +64,34 @@ func (s *Mode) Apply(req *http.Request, browser string) {
 		s.applyBrowserQuirks(req.Header, browser)
 	}
 
+	s.mu.Lock()
 	s.requestCount++
 	s.lastRequestTime = time.Now()
+	s.mu.Unlock()
+	return nil
 }
 
-func (s *Mode) applyDelay() {
-	if s.requestCount == 0 {
-		return
+func (s *Mode) applyDelay(ctx context.Context) error {
+	s.mu.Lock()
+	first := s.requestCount == 0
+	s.mu.Unlock()
+	if first {
+		return nil
 	}
 	if s.opts.HumanLikeDelays {
-		delay := s.opts.MinDelay + time.Duration(rand.Int63n(int64(s.opts.MaxDelay-s.opts.MinDelay)))
-		time.Sleep(delay)
+		delay := s.opts.MinDelay
+		if spread := s.opts.MaxDelay - s.opts.MinDelay; spread > 0 {
+			delay += time.Duration(rand.Int63n(int64(spread)))
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+	return nil
 }
 
 func (s *Mode) randomizeHeaders(h http.Header) {
@@ -0,0 +1,46 @@
+package stealth
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestApplyConcurrently applies stealth to many requests at once. Every
+// request must be counted and get its headers. Run it with -race.
+func TestApplyConcurrently(t *testing.T) {
+	m := New(Options{Enabled: true, HumanLikeDelays: true, MinDelay: time.Microsecond, MaxDelay: 2 * time.Microsecond, RandomizeHeaders: true, BrowserQuirks: true})
+
+	const workers, perWorker = 20, 50
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			browser := []string{"chrome", "firefox"}[i%2]
+			for j := 0; j < perWorker; j++ {
+				req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+				if err := m.Apply(req, browser); err != nil {
+					t.Error(err)
+					return
+				}
+				quirk := map[string]string{"chrome": "Sec-Fetch-Mode", "firefox": "Upgrade-Insecure-Requests"}[browser]
+				if req.Header.Get("Accept-Language") == "" || req.Header.Get(quirk) == "" {
+					t.Errorf("%s request missing stealth headers: %v", browser, req.Header)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requestCount != workers*perWorker {
+		t.Errorf("counted %d requests, want %d", m.requestCount, workers*perWorker)
+	}
+	if m.lastRequestTime.IsZero() {
+		t.Error("last request time not recorded")
+	}
+}
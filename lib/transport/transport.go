@@ -1,14 +1,35 @@
 package transport
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// CipherSuiteTransport is an http.RoundTripper whose TLS cipher suites can be
+// rotated while requests are in flight. Every proxy gets its own underlying
+// transport and connection pool, so a keep-alive connection opened through
+// one proxy is never reused for a request routed through another. Each
+// rotation installs a fresh set of transports, so a tls.Config is never
+// mutated after first use. It is safe for concurrent use.
 type CipherSuiteTransport struct {
-	*http.Transport
+	current atomic.Pointer[transportSet]
+	// rotateMu serialises rotations, so that each one closes the set the
+	// one before it installed.
+	rotateMu sync.Mutex
+}
+
+// transportSet holds the transports sharing one TLS profile.
+type transportSet struct {
+	template *http.Transport
+
+	mu      sync.Mutex
+	byProxy map[string]*http.Transport
 }
 
 func NewTransport() *CipherSuiteTransport {
@@ -17,7 +38,6 @@ func NewTransport() *CipherSuiteTransport {
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
-			DualStack: true,
 		}).DialContext,
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          100,
@@ -26,10 +46,90 @@ func NewTransport() *CipherSuiteTransport {
 		ExpectContinueTimeout: 1 * time.Second,
 		TLSClientConfig:       &tls.Config{},
 	}
-	return &CipherSuiteTransport{tr}
+	t := &CipherSuiteTransport{}
+	t.current.Store(newTransportSet(tr))
+	return t
+}
+
+func newTransportSet(template *http.Transport) *transportSet {
+	return &transportSet{template: template, byProxy: make(map[string]*http.Transport)}
+}
+
+// RoundTrip implements http.RoundTripper. The request is sent through the
+// transport dedicated to the proxy pinned in its context by WithProxy, or
+// through the direct transport if there is none.
+func (t *CipherSuiteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current.Load().forProxy(ProxyFromContext(req.Context())).RoundTrip(req)
 }
 
+// SetCipherSuites installs transports that offer suites. Requests already in
+// flight finish on the previous transports, whose idle connections are closed.
 func (t *CipherSuiteTransport) SetCipherSuites(suites []uint16) {
-	t.Transport.TLSClientConfig.CipherSuites = suites
-	t.Transport.TLSClientConfig.MinVersion = tls.VersionTLS12
-}
\ No newline at end of file
+	t.rotateMu.Lock()
+	defer t.rotateMu.Unlock()
+	prev := t.current.Load()
+	template := prev.template.Clone()
+	template.TLSClientConfig.CipherSuites = suites
+	template.TLSClientConfig.MinVersion = tls.VersionTLS12
+	t.current.Store(newTransportSet(template))
+	prev.closeIdle(nil)
+}
+
+// CloseIdleConnections closes the idle connections of every current transport.
+func (t *CipherSuiteTransport) CloseIdleConnections() {
+	t.current.Load().closeIdle(nil)
+}
+
+// CloseProxyConnections closes the idle connections opened through proxyURL,
+// e.g. after it has been reported as failing.
+func (t *CipherSuiteTransport) CloseProxyConnections(proxyURL *url.URL) {
+	t.current.Load().closeIdle(proxyURL)
+}
+
+// forProxy returns the transport for proxyURL, creating it on first use.
+func (ts *transportSet) forProxy(proxyURL *url.URL) *http.Transport {
+	if proxyURL == nil {
+		return ts.template
+	}
+	key := proxyURL.String()
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	tr, ok := ts.byProxy[key]
+	if !ok {
+		tr = ts.template.Clone()
+		tr.Proxy = http.ProxyURL(proxyURL)
+		ts.byProxy[key] = tr
+	}
+	return tr
+}
+
+// closeIdle closes idle connections for proxyURL, or for all transports if it is nil.
+func (ts *transportSet) closeIdle(proxyURL *url.URL) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if proxyURL != nil {
+		if tr, ok := ts.byProxy[proxyURL.String()]; ok {
+			tr.CloseIdleConnections()
+		}
+		return
+	}
+	ts.template.CloseIdleConnections()
+	for _, tr := range ts.byProxy {
+		tr.CloseIdleConnections()
+	}
+}
+
+type proxyContextKey struct{}
+
+// WithProxy returns a context that routes requests made with it through
+// proxyURL when sent by a CipherSuiteTransport.
+func WithProxy(ctx context.Context, proxyURL *url.URL) context.Context {
+	return context.WithValue(ctx, proxyContextKey{}, proxyURL)
+}
+
+// ProxyFromContext returns the proxy set by WithProxy, or nil.
+func ProxyFromContext(ctx context.Context) *url.URL {
+	u, _ := ctx.Value(proxyContextKey{}).(*url.URL)
+	return u
+}
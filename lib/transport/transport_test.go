@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSetCipherSuitesUnderLoad rotates the cipher suites from many
+// goroutines while requests are in flight. Every rotation must close the
+// set it replaces, so once the current set is closed too, no connection is
+// left open. Run it with -race.
+func TestSetCipherSuitesUnderLoad(t *testing.T) {
+	var mu sync.Mutex
+	open := map[net.Conn]bool{}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch state {
+		case http.StateNew:
+			open[c] = true
+		case http.StateClosed, http.StateHijacked:
+			delete(open, c)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	tr := NewTransport()
+	tr.current.Load().template.TLSClientConfig.RootCAs = srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	client := &http.Client{Transport: tr}
+	suites := [][]uint16{
+		{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305},
+	}
+
+	const workers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				resp, err := client.Get(srv.URL)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				tr.SetCipherSuites(suites[(i+j)%len(suites)])
+			}
+		}(i)
+	}
+	wg.Wait()
+	tr.CloseIdleConnections()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(open)
+		mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections left open by replaced transports", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}